@@ -6,12 +6,24 @@
 // It is designed for ease of use before speed.
 //
 // It supports binary marshaling of all go types, excluding chans, funcs and
-// unsafePointers.
+// unsafePointers. Values held in interfaces are supported if their concrete
+// types were registered using Register.
 //
 // Ints and Uints of any size are encoded as VarInts, floats and complex
-// numbers using binary encoding in LittleEndian order, and strings, arrays,
-// slices and maps are prefixed by a number (varint) specifying number of
-// their elements then written as a LittleEndian byte stream.
+// numbers using binary encoding in LittleEndian order at the width of their
+// type, and strings, arrays, slices and maps are prefixed by a number
+// (varint) specifying number of their elements then written as a
+// LittleEndian byte stream. Byte arrays, byte slices and strings are written
+// as raw bytes in a single write. Set WideFloats option to read and write
+// float32 and complex64 values widened to 64 bits as older versions did.
+//
+// Structs are written field by field. Unexported fields and fields tagged
+// with `binaryex:"-"` are skipped, unless unexported fields are tagged with
+// `binaryex:"include"`. See TagName for field tag options.
+//
+// Embedded pointers are written with a presence marker in every NilMode.
+// This breaks the wire format of older versions in NilLegacy mode, which
+// wrote embedded pointers as other pointers; such data can not be read.
 //
 // Write functions take values or pointers to values. If a Pointer value was
 // passed to a Write function it is dereferenced up to the value itself then
@@ -23,23 +35,75 @@
 //
 // If a pointer with a a nil value was Written, when Read, the pointer will
 // have its' value allocated (not nil) and value set to zero of that type.
+// Likewise, nil slices and maps are read as empty ones. This is the default
+// NilLegacy mode; set NilMode to NilPreserve in DefaultEncoderOptions and
+// DefaultDecoderOptions or in options of an Encoder and Decoder pair to have
+// nil values read back as nil. Nil interfaces are always read as nil.
+//
+// Pointers are written as copies of values they point to, so pointers that
+// are shared within a value are read back as distinct pointers and values
+// that contain themselves fail with ErrCycle. Set References option of an
+// Encoder and Decoder pair to preserve shared and cyclic pointers.
+//
+// Functions do not panic on invalid arguments. A nil writer, reader or value
+// returns an error wrapping ErrInvalidArgument, a Read function given a
+// value that can not be set returns ErrUnadressableValue and a typed
+// function like WriteStruct given a value of another kind returns
+// ErrUnsupportedValue, each as an *EncodeError or a *DecodeError holding the
+// offending type. Panics raised while writing or reading a value, by
+// reflection or by marshaler methods, are recovered and returned the same
+// way. Only Register and RegisterCodec panic, as they are meant to be
+// called from init functions.
+//
+// If a value supports encoding.Binary(un)Marshaler it is preferred, followed
+// by gob.GobEncoder/GobDecoder and encoding.TextMarshaler/TextUnmarshaler.
+// Marshalers option of an Encoder and Decoder pair changes the order or
+// disables them. Watch out for infinite loops if calling Read, ReadReflect,
+// Write or WriteReflect from a marshaler method. Marshaler methods promoted
+// from an embedded type are not used, so an embedded time.Time does not take
+// over encoding of the struct that embeds it. Methods the struct declares
+// itself are used as usual.
+//
+// Types that can not be given methods, like types of other packages, can
+// have their encoding defined by RegisterCodec, or per Encoder and Decoder
+// by their RegisterCodec methods. Codecs take precedence over methods.
+//
+// For speed, the binaryex-gen command in cmd/binaryex-gen generates
+// MarshalBinaryEx and UnmarshalBinaryEx methods for struct types which write
+// the same bytes without reflection. Encoders and Decoders call them if
+// present, see Marshaler and Unmarshaler.
+//
+// If an unsupported value is encountered functions will error. Errors that
+// occur while writing or reading a value are returned as an *EncodeError or
+// a *DecodeError holding the path to the failing field, like
+// Order.Items[3].Price, and the stream offset. They wrap the underlying
+// error and match ErrBinaryEx using errors.Is.
 //
-// All functions can panic if they encounter invalid parameters as most checks
-// are ommited for performance reasons.
+// Read functions read values in full regardless of how many bytes a single
+// Read of the underlying reader returns. If a stream ends while a value is
+// being read they return an error wrapping io.ErrUnexpectedEOF.
 //
-// If a value supports encoding.Binary(un)Marshaler it is preferred. Watch out
-// for infinite loops if calling Read, ReadReflect, Write or WriteReflect from
-// a BinaryMarshaler or BinaryUnmarshaler implementor.
+// Maps are written in random order of their keys unless the Canonical
+// option is set in which case equal values always encode to equal bytes.
 //
-// If an unsupported value is encountered functions will error.
+// Marshal, AppendMarshal and Unmarshal encode to and decode from byte
+// slices directly, without an io.Writer or an io.Reader in between.
+//
+// Encode and Decode are their type safe generic counterparts and Codec
+// encodes and decodes values of a single type with plans compiled once.
+//
+// Package level functions encode and decode a single value at a time. For
+// streams of values use an Encoder or a Decoder which buffer their io and
+// cache compiled encoding plans per type.
+//
+// RecordWriter and RecordReader frame each value as a record with a length
+// prefix and an optional CRC32C checksum, so that a reader can skip a
+// record that fails to decode and resynchronise after corrupt data.
 package binaryex
 
 import (
-	"encoding"
-	"encoding/binary"
 	"io"
 	"reflect"
-	"strings"
 
 	"github.com/vedranvuk/errorex"
 )
@@ -51,8 +115,35 @@ var (
 	ErrUnsupportedValue = ErrBinaryEx.Wrap("unsupported value")
 	// ErrUnadressableValue is returned when a non-pointer value is passed to a Read* function.
 	ErrUnadressableValue = ErrBinaryEx.Wrap("unadressable value")
+	// ErrInvalidArgument is returned when a nil or invalid argument is
+	// passed to a function or when a panic occurs writing or reading a value.
+	ErrInvalidArgument = ErrBinaryEx.Wrap("invalid argument")
 	// ErrUnexpected is returned when an unexpected value is read.
 	ErrUnexpected = ErrBinaryEx.Wrap("unexpected value")
+	// ErrInvalidTag is returned when a struct field has an invalid binaryex
+	// tag.
+	ErrInvalidTag = ErrBinaryEx.Wrap("invalid struct tag")
+	// ErrUnregisteredType is returned when a value held in an interface is
+	// of a type that was not registered using Register.
+	ErrUnregisteredType = ErrBinaryEx.Wrap("unregistered type")
+	// ErrInvalidHeader is returned when a self describing stream has an
+	// invalid header.
+	ErrInvalidHeader = ErrBinaryEx.Wrap("invalid stream header")
+	// ErrLimitExceeded is returned when a value being read exceeds a limit
+	// set in DecoderOptions.
+	ErrLimitExceeded = ErrBinaryEx.Wrap("limit exceeded")
+	// ErrIncompatibleType is returned when a value in a self describing
+	// stream can not be read into a value of the requested type.
+	ErrIncompatibleType = ErrBinaryEx.Wrap("incompatible type")
+	// ErrNotCanonical is returned when a map can not be written in canonical
+	// order or when a map being read with Canonical option set is not.
+	ErrNotCanonical = ErrBinaryEx.Wrap("not canonical")
+	// ErrCycle is returned when a value being written contains itself
+	// through a pointer, a slice or a map and References option is not set.
+	ErrCycle = ErrBinaryEx.Wrap("cycle detected")
+	// ErrCorruptRecord is returned when a record read by a RecordReader is
+	// not valid.
+	ErrCorruptRecord = ErrBinaryEx.Wrap("corrupt record")
 )
 
 // readByteWrapper wraps an io.Reader and implements a ReadByte method.
@@ -65,54 +156,18 @@ type readByteWrapper struct {
 // ReadByte implements the ReadByte method.
 func (rbw *readByteWrapper) ReadByte() (b byte, err error) {
 
-	if _, err = rbw.Read(rbw.p[:]); err != nil {
+	if _, err = io.ReadFull(rbw.Reader, rbw.p[:]); err != nil {
 		return
 	}
 	return rbw.p[0], nil
 }
 
-// wrapReader wraps an io.Reader in a io.ByteReader implementor.
-func wrapReader(r io.Reader) *readByteWrapper {
-	return &readByteWrapper{r, [1]byte{0}}
-}
-
 // WriteReflect writes a reflect value v to writer w or returns an error
 // if one occured.
-func WriteReflect(w io.Writer, v reflect.Value) (err error) {
-	// Dereference pointers down to value.
-	for v.Kind() == reflect.Ptr {
-		v = v.Elem()
-	}
-	// Write 0 for nil values.
-	if !v.IsValid() {
-		return WriteNumber(w, 0)
-	}
-	// Try BinaryMarshaler.
-	if bm, ok := v.Interface().(encoding.BinaryMarshaler); ok {
-		p, e := bm.MarshalBinary()
-		if e != nil {
-			return err
-		}
-		return Write(w, p)
-	}
-	// Write value.
-	switch v.Kind() {
-	case reflect.Bool:
-		err = WriteBoolReflect(w, v)
-	case reflect.String:
-		err = WriteStringReflect(w, v)
-	case reflect.Array:
-		err = WriteArrayReflect(w, v)
-	case reflect.Slice:
-		err = WriteSliceReflect(w, v)
-	case reflect.Map:
-		err = WriteMapReflect(w, v)
-	case reflect.Struct:
-		err = WriteStructReflect(w, v)
-	default:
-		err = WriteNumberReflect(w, v)
-	}
-	return
+func WriteReflect(w io.Writer, v reflect.Value) error {
+	e := getEncoder(w)
+	defer putEncoder(e)
+	return e.EncodeValue(v)
 }
 
 // Write writes value val to writer w or returns an error if one occured.
@@ -121,74 +176,101 @@ func Write(w io.Writer, val interface{}) error {
 	return WriteReflect(w, v)
 }
 
-// ReadReflect reads a value from reader r and puts it into v or returns an
-// error if one occured.
-func ReadReflect(r io.Reader, v reflect.Value) (err error) {
-	// Must be addressable.
-	if !v.CanAddr() {
-		return ErrUnadressableValue
-	}
-	// Alloc a new concrete value,
-	// or a pointer to value if v is a pointer.
-	ptr := false
-	var pv = reflect.Value{}
-	if v.Kind() == reflect.Ptr {
-		pv = reflect.New(v.Type().Elem())
-		ptr = true
-	} else {
-		pv = reflect.New(v.Type())
-	}
-	nv := reflect.Indirect(pv)
-	// If a pointer, read the pointer.
-	if nv.Kind() == reflect.Ptr {
-		if err := ReadReflect(r, nv); err != nil {
-			return err
-		}
-		// Set value.
-		if ptr {
-			v.Set(pv)
-		} else {
-			v.Set(nv)
-		}
-		return
-	}
-	// Try BinaryMarshaler.
-	if nv.IsValid() {
-		if bu, ok := nv.Interface().(encoding.BinaryUnmarshaler); ok {
-			b := []byte{}
-			if err = ReadSlice(r, &b); err != nil {
-				return
-			}
-			return bu.UnmarshalBinary(b)
-		}
-	}
-	// Read value to temp.
-	switch pv.Elem().Type().Kind() {
-	case reflect.Bool:
-		err = ReadBoolReflect(r, nv)
-	case reflect.String:
-		err = ReadStringReflect(r, nv)
-	case reflect.Array:
-		err = ReadArrayReflect(r, nv)
-	case reflect.Slice:
-		err = ReadSliceReflect(r, nv)
-	case reflect.Map:
-		err = ReadMapReflect(r, nv)
-	case reflect.Struct:
-		err = ReadStructReflect(r, nv)
-	default:
-		err = ReadNumberReflect(r, nv)
-	}
-	if err != nil {
-		return err
+// countWriter is an io.Writer that discards bytes written to it and counts
+// them.
+type countWriter struct {
+	n int
+}
+
+// Write implements io.Writer.
+func (cw *countWriter) Write(p []byte) (int, error) {
+	cw.n += len(p)
+	return len(p), nil
+}
+
+// WriteString implements io.StringWriter.
+func (cw *countWriter) WriteString(s string) (int, error) {
+	cw.n += len(s)
+	return len(s), nil
+}
+
+// SizeReflect returns the number of bytes WriteReflect would write for a
+// reflect value v or an error if one occured. Values written by marshaler
+// methods are marshaled to determine their size.
+func SizeReflect(v reflect.Value) (int, error) {
+	cw := &countWriter{}
+	e := getEncoder(cw)
+	defer putEncoder(e)
+	if err := e.EncodeValue(v); err != nil {
+		return 0, err
 	}
-	// Set value.
-	if ptr {
-		v.Set(pv)
-	} else {
-		v.Set(nv)
+	return cw.n, nil
+}
+
+// Size returns the number of bytes Write would write for value val or an
+// error if one occured.
+func Size(val interface{}) (int, error) {
+	v := reflect.Indirect(reflect.ValueOf(val))
+	return SizeReflect(v)
+}
+
+// sliceWriter is an io.Writer that appends bytes written to it to a slice.
+type sliceWriter struct {
+	p []byte
+}
+
+// Write implements io.Writer.
+func (sw *sliceWriter) Write(p []byte) (int, error) {
+	sw.p = append(sw.p, p...)
+	return len(p), nil
+}
+
+// WriteByte implements io.ByteWriter.
+func (sw *sliceWriter) WriteByte(c byte) error {
+	sw.p = append(sw.p, c)
+	return nil
+}
+
+// WriteString implements io.StringWriter.
+func (sw *sliceWriter) WriteString(s string) (int, error) {
+	sw.p = append(sw.p, s...)
+	return len(s), nil
+}
+
+// AppendMarshal appends the encoding of value val to dst and returns the
+// extended slice or returns dst and an error if one occured. Values are
+// encoded as by Write.
+func AppendMarshal(dst []byte, val interface{}) ([]byte, error) {
+	sw := &sliceWriter{dst}
+	e := getEncoder(sw)
+	defer putEncoder(e)
+	if err := e.EncodeValue(reflect.Indirect(reflect.ValueOf(val))); err != nil {
+		return dst, err
 	}
-	return
+	return sw.p, nil
+}
+
+// Marshal returns the encoding of value val or an error if one occured.
+// Values are encoded as by Write.
+func Marshal(val interface{}) ([]byte, error) {
+	return AppendMarshal(nil, val)
+}
+
+// Unmarshal reads a value from data and puts it into val which must be a
+// pointer or returns an error if one occured. Values are decoded as by
+// Read, reading directly from data. Data after the value is ignored.
+func Unmarshal(data []byte, val interface{}) error {
+	d := getSliceDecoder(data)
+	defer putDecoder(d)
+	return d.DecodeValue(reflect.Indirect(reflect.ValueOf(val)))
+}
+
+// ReadReflect reads a value from reader r and puts it into v or returns an
+// error if one occured.
+func ReadReflect(r io.Reader, v reflect.Value) error {
+	d := getDecoder(r)
+	defer putDecoder(d)
+	return d.DecodeValue(v)
 }
 
 // Read reads a value from r and puts it into val or returns an error
@@ -198,15 +280,38 @@ func Read(r io.Reader, val interface{}) error {
 	return ReadReflect(r, v)
 }
 
+// writeKind writes v of one of kinds to w using plan f or, if f is nil, as
+// WriteReflect does or returns an error if one occured.
+func writeKind(w io.Writer, v reflect.Value, f encFunc, kinds ...reflect.Kind) error {
+	e := getEncoder(w)
+	defer putEncoder(e)
+	if err := checkArg(v, false, kinds...); err != nil {
+		return e.encodeError(err, valueType(v))
+	}
+	if f == nil {
+		return e.EncodeValue(v)
+	}
+	return e.encodeError(e.encodeRaw(v, f), v.Type())
+}
+
+// readKind reads a value from r into v of one of kinds using plan f or, if
+// f is nil, as ReadReflect does or returns an error if one occured.
+func readKind(r io.Reader, v reflect.Value, f decFunc, kinds ...reflect.Kind) error {
+	d := getDecoder(r)
+	defer putDecoder(d)
+	if err := checkArg(v, true, kinds...); err != nil {
+		return d.decodeError(err, valueType(v))
+	}
+	if f == nil {
+		return d.decodeValue(v, nil)
+	}
+	return d.decodeError(d.decodeRaw(v, f), v.Type())
+}
+
 // WriteBoolReflect writes a bool reflect value v to writer w or returns an
 // error if one occured.
-func WriteBoolReflect(w io.Writer, v reflect.Value) (err error) {
-	if v.Bool() {
-		_, err = w.Write([]byte{1})
-	} else {
-		_, err = w.Write([]byte{0})
-	}
-	return
+func WriteBoolReflect(w io.Writer, v reflect.Value) error {
+	return writeKind(w, v, encBool, reflect.Bool)
 }
 
 // WriteBool writes bool value val to writer w or returns an error if one
@@ -218,26 +323,8 @@ func WriteBool(w io.Writer, b bool) error {
 
 // ReadBoolReflect reads a bool value from reader r and puts it into v or
 // returns an error if one occured.
-func ReadBoolReflect(r io.Reader, v reflect.Value) (err error) {
-
-	if !v.CanAddr() {
-		return ErrUnadressableValue
-	}
-
-	var p [1]byte
-	if _, err = r.Read(p[:]); err != nil {
-		return
-	}
-
-	if p[0] == 0 {
-		v.SetBool(false)
-		return
-	}
-	if p[0] == 1 {
-		v.SetBool(true)
-		return
-	}
-	return ErrUnexpected
+func ReadBoolReflect(r io.Reader, v reflect.Value) error {
+	return readKind(r, v, decBool, reflect.Bool)
 }
 
 // ReadBool reads a bool value from r and puts it into val or returns an error
@@ -249,26 +336,20 @@ func ReadBool(r io.Reader, b interface{}) error {
 
 // WriteNumberReflect writes a number reflect value v to writer w or returns an
 // error if one occured.
-func WriteNumberReflect(w io.Writer, v reflect.Value) (err error) {
-
+func WriteNumberReflect(w io.Writer, v reflect.Value) error {
+	fn := encUnsupported
 	switch v.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		buf := make([]byte, binary.MaxVarintLen64)
-		n := binary.PutVarint(buf, v.Int())
-		_, err = w.Write(buf[:n])
+		fn = encInt
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
 		reflect.Uint64:
-		buf := make([]byte, binary.MaxVarintLen64)
-		n := binary.PutUvarint(buf, v.Uint())
-		_, err = w.Write(buf[:n])
+		fn = encUint
 	case reflect.Float32, reflect.Float64:
-		err = binary.Write(w, binary.LittleEndian, v.Float())
+		fn = encFloat
 	case reflect.Complex64, reflect.Complex128:
-		err = binary.Write(w, binary.LittleEndian, v.Complex())
-	default:
-		err = ErrUnsupportedValue
+		fn = encComplex
 	}
-	return
+	return writeKind(w, v, fn)
 }
 
 // WriteNumber writes number value val to writer w or returns an error if one
@@ -280,44 +361,20 @@ func WriteNumber(w io.Writer, n interface{}) error {
 
 // ReadNumberReflect reads a number value from reader r and puts it into v or
 // returns an error if one occured.
-func ReadNumberReflect(r io.Reader, v reflect.Value) (err error) {
-
-	if !v.CanAddr() {
-		return ErrUnadressableValue
-	}
-
-	rw := wrapReader(r)
-
-	switch v.Type().Kind() {
+func ReadNumberReflect(r io.Reader, v reflect.Value) error {
+	fn := decUnsupported
+	switch v.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		n, e := binary.ReadVarint(rw)
-		if e != nil {
-			return e
-		}
-		v.SetInt(n)
+		fn = decInt
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
 		reflect.Uint64:
-		n, e := binary.ReadUvarint(rw)
-		if e != nil {
-			return e
-		}
-		v.SetUint(n)
+		fn = decUint
 	case reflect.Float32, reflect.Float64:
-		var n float64
-		if err = binary.Read(rw, binary.LittleEndian, &n); err != nil {
-			return
-		}
-		v.SetFloat(n)
+		fn = decFloat
 	case reflect.Complex64, reflect.Complex128:
-		var n complex128
-		if err = binary.Read(rw, binary.LittleEndian, &n); err != nil {
-			return
-		}
-		v.SetComplex(n)
-	default:
-		err = ErrUnsupportedValue
+		fn = decComplex
 	}
-	return
+	return readKind(r, v, fn)
 }
 
 // ReadNumber reads a number value from r and puts it into val or returns an
@@ -329,12 +386,8 @@ func ReadNumber(r io.Reader, n interface{}) error {
 
 // WriteStringReflect writes a reflect value v to writer w or returns an error
 // if one occured.
-func WriteStringReflect(w io.Writer, v reflect.Value) (err error) {
-	if err = WriteNumber(w, v.Len()); err != nil {
-		return err
-	}
-	_, err = w.Write([]byte(v.String()))
-	return
+func WriteStringReflect(w io.Writer, v reflect.Value) error {
+	return writeKind(w, v, encString, reflect.String)
 }
 
 // WriteString writes string value val to writer w or returns an error if one
@@ -346,30 +399,8 @@ func WriteString(w io.Writer, s string) error {
 
 // ReadSgtringReflect reads a string value from reader r and puts it into v or
 // returns an error if one occured.
-func ReadStringReflect(r io.Reader, v reflect.Value) (err error) {
-
-	if !v.CanAddr() {
-		return ErrUnadressableValue
-	}
-
-	l := 0
-	if err = ReadNumber(r, &l); err != nil {
-		return err
-	}
-	if l < 0 {
-		return ErrUnexpected
-	}
-	if l == 0 {
-		v.Set(reflect.Zero(v.Type()))
-		return
-	}
-	buf := make([]byte, l)
-	_, err = r.Read(buf)
-	if err != nil {
-		return err
-	}
-	v.SetString(string(buf))
-	return
+func ReadStringReflect(r io.Reader, v reflect.Value) error {
+	return readKind(r, v, decString, reflect.String)
 }
 
 // ReadString reads a value from r and puts it into val or returns an error if
@@ -381,13 +412,8 @@ func ReadString(r io.Reader, s interface{}) error {
 
 // WriteArrayReflect writes an array reflect value v to writer w or returns an
 // error if one occured.
-func WriteArrayReflect(w io.Writer, v reflect.Value) (err error) {
-	for i := 0; i < v.Type().Len(); i++ {
-		if err = WriteReflect(w, v.Index(i)); err != nil {
-			break
-		}
-	}
-	return
+func WriteArrayReflect(w io.Writer, v reflect.Value) error {
+	return writeKind(w, v, nil, reflect.Array)
 }
 
 // WriteArray writes array value val to writer w or returns an error if one
@@ -399,18 +425,8 @@ func WriteArray(w io.Writer, val interface{}) error {
 
 // ReadArrayReflect reads an array value from reader r and puts it into v or
 // returns an error if one occured.
-func ReadArrayReflect(r io.Reader, v reflect.Value) (err error) {
-
-	if !v.CanAddr() {
-		return ErrUnadressableValue
-	}
-
-	for i := 0; i < v.Type().Len(); i++ {
-		if err = ReadReflect(r, v.Index(i)); err != nil {
-			break
-		}
-	}
-	return
+func ReadArrayReflect(r io.Reader, v reflect.Value) error {
+	return readKind(r, v, nil, reflect.Array)
 }
 
 // ReadArray reads an array value from r and puts it into val or returns an
@@ -422,16 +438,8 @@ func ReadArray(r io.Reader, val interface{}) error {
 
 // WriteSliceReflect writes a slice reflect value v to writer w or returns an
 // error if one occured.
-func WriteSliceReflect(w io.Writer, v reflect.Value) (err error) {
-	if err = WriteNumber(w, v.Len()); err != nil {
-		return
-	}
-	for i := 0; i < v.Len(); i++ {
-		if err = WriteReflect(w, v.Index(i)); err != nil {
-			break
-		}
-	}
-	return
+func WriteSliceReflect(w io.Writer, v reflect.Value) error {
+	return writeKind(w, v, nil, reflect.Slice)
 }
 
 // WriteSlice writes slice value val to writer w or returns an error if one
@@ -443,26 +451,8 @@ func WriteSlice(w io.Writer, val interface{}) error {
 
 // ReadSliceReflect reads a slice value from reader r and puts it into v or
 // returns an error if one occured.
-func ReadSliceReflect(r io.Reader, v reflect.Value) (err error) {
-
-	if !v.CanAddr() {
-		return ErrUnadressableValue
-	}
-
-	l := 0
-	if err = ReadNumber(r, &l); err != nil {
-		return
-	}
-	if l < 0 {
-		return ErrUnexpected
-	}
-	v.Set(reflect.MakeSlice(v.Type(), l, l))
-	for i := 0; i < l; i++ {
-		if err = ReadReflect(r, v.Index(i)); err != nil {
-			break
-		}
-	}
-	return
+func ReadSliceReflect(r io.Reader, v reflect.Value) error {
+	return readKind(r, v, nil, reflect.Slice)
 }
 
 // ReadSlice reads a slice value from r and puts it into val or returns an error
@@ -474,21 +464,8 @@ func ReadSlice(r io.Reader, val interface{}) error {
 
 // WriteMapReflect writes a map reflect value v to writer w or returns an error
 // if one occured.
-func WriteMapReflect(w io.Writer, v reflect.Value) (err error) {
-
-	if err = WriteNumber(w, v.Len()); err != nil {
-		return
-	}
-	for _, mk := range v.MapKeys() {
-		mv := v.MapIndex(mk)
-		if err = WriteReflect(w, mk); err != nil {
-			break
-		}
-		if err = WriteReflect(w, mv); err != nil {
-			break
-		}
-	}
-	return
+func WriteMapReflect(w io.Writer, v reflect.Value) error {
+	return writeKind(w, v, nil, reflect.Map)
 }
 
 // WriteMap writes map value val to writer w or returns an error if one occured.
@@ -499,34 +476,8 @@ func WriteMap(w io.Writer, val interface{}) error {
 
 // ReadMapReflect reads a map value from reader r and puts it into v or returns
 // an error if one occured.
-func ReadMapReflect(r io.Reader, v reflect.Value) (err error) {
-
-	if !v.CanAddr() {
-		return ErrUnadressableValue
-	}
-
-	l := 0
-	if err = ReadNumber(r, &l); err != nil {
-		return
-	}
-	if l < 0 {
-		return ErrUnexpected
-	}
-	kt := v.Type().Key()
-	vt := v.Type().Elem()
-	v.Set(reflect.MakeMap(v.Type()))
-	for i := 0; i < l; i++ {
-		kv := reflect.Indirect(reflect.New(kt))
-		if err = ReadReflect(r, kv); err != nil {
-			break
-		}
-		vv := reflect.Indirect(reflect.New(vt))
-		if err = ReadReflect(r, vv); err != nil {
-			break
-		}
-		v.SetMapIndex(kv, vv)
-	}
-	return
+func ReadMapReflect(r io.Reader, v reflect.Value) error {
+	return readKind(r, v, nil, reflect.Map)
 }
 
 // ReadMap reads a map value from r and puts it into val or returns an error if
@@ -538,20 +489,8 @@ func ReadMap(r io.Reader, val interface{}) error {
 
 // WriteStructReflect writes a struct reflect value v to writer w or returns an
 // error if one occured.
-func WriteStructReflect(w io.Writer, v reflect.Value) (err error) {
-	for i := 0; i < v.NumField(); i++ {
-		fname := v.Type().Field(i).Name
-		if fname == "_" {
-			continue
-		}
-		if fname[0] == strings.ToLower(fname)[0] {
-			continue
-		}
-		if err = WriteReflect(w, v.Field(i)); err != nil {
-			break
-		}
-	}
-	return
+func WriteStructReflect(w io.Writer, v reflect.Value) error {
+	return writeKind(w, v, nil, reflect.Struct)
 }
 
 // WriteStruct writes struct value val to writer w or returns an error if one
@@ -563,28 +502,8 @@ func WriteStruct(w io.Writer, val interface{}) error {
 
 // ReadStructReflect reads a struct value from reader r and puts it into v or
 // returns an error if one occured.
-func ReadStructReflect(r io.Reader, v reflect.Value) (err error) {
-
-	if !v.CanAddr() {
-		return ErrUnadressableValue
-	}
-
-	for i := 0; i < v.NumField(); i++ {
-		fname := v.Type().Field(i).Name
-		if fname == "_" {
-			continue
-		}
-		if fname[0] == strings.ToLower(fname)[0] {
-			continue
-		}
-		if !v.Field(i).CanSet() {
-			continue
-		}
-		if err = ReadReflect(r, v.Field(i)); err != nil {
-			break
-		}
-	}
-	return
+func ReadStructReflect(r io.Reader, v reflect.Value) error {
+	return readKind(r, v, nil, reflect.Struct)
 }
 
 // ReadStruct reads a struct value from r and puts it into val or returns an
@@ -115,6 +115,29 @@ func (at *AllTypes) init() {
 	at.MarshalableTypes.init()
 }
 
+// roundTrip writes out by an Encoder with eopts, reads it back by a Decoder
+// with dopts into a new value of the type of out and returns the value read.
+func roundTrip(t *testing.T, eopts EncoderOptions, dopts DecoderOptions, out interface{}) interface{} {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	if err := NewEncoderWithOptions(buf, eopts).Encode(out); err != nil {
+		t.Fatalf("%+v: Encode failed: %v", eopts, err)
+	}
+	in := reflect.New(reflect.TypeOf(out))
+	if err := NewDecoderWithOptions(buf, dopts).Decode(in.Interface()); err != nil {
+		t.Fatalf("%+v: Decode failed: %v", dopts, err)
+	}
+	return in.Elem().Interface()
+}
+
+// checkRoundTrip fails if out is not read back equal by roundTrip.
+func checkRoundTrip(t *testing.T, eopts EncoderOptions, dopts DecoderOptions, out interface{}) {
+	t.Helper()
+	if in := roundTrip(t, eopts, dopts, out); !reflect.DeepEqual(in, out) {
+		t.Fatalf("%+v: Encode/Decode missmatch: in\n%v, out:\n%v\n", eopts, in, out)
+	}
+}
+
 func TestReadWriteBase(t *testing.T) {
 	buf := bytes.NewBuffer(nil)
 	out := BaseTypes{}
@@ -132,6 +155,74 @@ func TestReadWriteBase(t *testing.T) {
 	}
 }
 
+func TestSize(t *testing.T) {
+	base := BaseTypes{}
+	base.init()
+	all := AllTypes{}
+	all.init()
+	ptrs := PointerTypes{}
+	ptrs.init()
+	for _, val := range []interface{}{
+		nil, true, -1 << 40, uint8(200), "size", [3]int{1, 300, -70000},
+		[]string{"a", "", string(make([]byte, 200))}, map[int]bool{1: true},
+		base, &all, ptrs, NilTypes{}, make([]byte, 1000),
+	} {
+		buf := bytes.NewBuffer(nil)
+		if err := Write(buf, val); err != nil {
+			t.Fatal("Write failed", err)
+		}
+		n, err := Size(val)
+		if err != nil {
+			t.Fatal("Size failed", err)
+		}
+		if n != buf.Len() {
+			t.Fatalf("%T: expected size %d, got %d", val, buf.Len(), n)
+		}
+	}
+	if _, err := Size(make(chan int)); err == nil {
+		t.Fatal("expected error for unsupported value")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	out := AllTypes{}
+	out.init()
+	// Single entry so that map order does not differ between encodings.
+	out.MapField = map[string]int{"one": 1}
+	buf := bytes.NewBuffer(nil)
+	if err := Write(buf, out); err != nil {
+		t.Fatal("Write failed", err)
+	}
+	data, err := Marshal(&out)
+	if err != nil {
+		t.Fatal("Marshal failed", err)
+	}
+	if !bytes.Equal(data, buf.Bytes()) {
+		t.Fatal("Marshal and Write missmatch")
+	}
+	prefix := []byte("prefix")
+	if data, err = AppendMarshal(prefix, out); err != nil {
+		t.Fatal("AppendMarshal failed", err)
+	}
+	if !bytes.HasPrefix(data, prefix) || !bytes.Equal(data[len(prefix):], buf.Bytes()) {
+		t.Fatal("AppendMarshal and Write missmatch")
+	}
+	in := AllTypes{}
+	if err := Unmarshal(data[len(prefix):], &in); err != nil {
+		t.Fatal("Unmarshal failed", err)
+	}
+	if !in.TimeField.Equal(out.TimeField) {
+		t.Fatalf("Marshal/Unmarshal missmatch: in\n%v, out:\n%v\n", in, out)
+	}
+	in.TimeField = out.TimeField
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("Marshal/Unmarshal missmatch: in\n%v, out:\n%v\n", in, out)
+	}
+	if _, err := AppendMarshal(prefix, make(chan int)); err == nil {
+		t.Fatal("expected error for unsupported value")
+	}
+}
+
 func TestBool(t *testing.T) {
 	buf := bytes.NewBuffer(nil)
 	out := make(map[int]bool)
@@ -491,3 +582,15 @@ func BenchmarkWriteStruct(b *testing.B) {
 		WriteStruct(buf, in)
 	}
 }
+
+func BenchmarkUnmarshal(b *testing.B) {
+	b.StopTimer()
+	in := BaseTypes{}
+	in.init()
+	data, _ := Marshal(in)
+	var out BaseTypes
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		Unmarshal(data, &out)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// IsCanonical reads a value from r into val like Read and reports whether
+// its' maps were written in canonical order. If reading fails for another
+// reason the error is returned.
+func IsCanonical(r io.Reader, val interface{}) (bool, error) {
+	d := getDecoder(r)
+	defer putDecoder(d)
+	d.opts.Canonical = true
+	err := d.Decode(val)
+	if errors.Is(err, ErrNotCanonical) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// isOrderedKind returns true if keys of kind k are put in canonical order
+// by their value instead of by their encoding.
+func isOrderedKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr, reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// lessOrdered returns true if a is less than b. a and b must be of the same
+// ordered kind. NaN floats are never less than any value.
+func lessOrdered(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	case reflect.String:
+		return a.String() < b.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	}
+	return false
+}
+
+// keyEncoding writes the canonical sort key of map key k to buf.
+//
+// It is the encoding of k by a new Encoder in mode nm so that it does not
+// depend on interface types written to the stream before k.
+func keyEncoding(buf *bytes.Buffer, nm NilMode, k reflect.Value) error {
+	e := &Encoder{w: buf, opts: EncoderOptions{NilMode: nm, Canonical: true}}
+	return encPlanFor(k.Type())(e, k)
+}
+
+// sortedKeys returns keys of map v in canonical order. Keys of ordered
+// kinds are sorted by value and all other keys by their encoding. If two
+// keys can not be ordered, as NaN floats or pointers to equal values,
+// ErrNotCanonical is returned.
+func (e *Encoder) sortedKeys(v reflect.Value) ([]reflect.Value, error) {
+	keys := v.MapKeys()
+	if isOrderedKind(v.Type().Key().Kind()) {
+		sort.Slice(keys, func(i, j int) bool {
+			return lessOrdered(keys[i], keys[j])
+		})
+		for i := 1; i < len(keys); i++ {
+			if !lessOrdered(keys[i-1], keys[i]) {
+				return nil, ErrNotCanonical
+			}
+		}
+		return keys, nil
+	}
+	var (
+		buf  bytes.Buffer
+		offs = make([]int, len(keys)+1)
+	)
+	for i, k := range keys {
+		if err := keyEncoding(&buf, e.opts.NilMode, k); err != nil {
+			return nil, err
+		}
+		offs[i+1] = buf.Len()
+	}
+	p := buf.Bytes()
+	enc := make([][]byte, len(keys))
+	for i := range keys {
+		enc[i] = p[offs[i]:offs[i+1]]
+	}
+	sort.Sort(keySorter{keys, enc})
+	for i := 1; i < len(enc); i++ {
+		if bytes.Compare(enc[i-1], enc[i]) >= 0 {
+			return nil, ErrNotCanonical
+		}
+	}
+	return keys, nil
+}
+
+// keySorter sorts map keys by their encodings.
+type keySorter struct {
+	keys []reflect.Value
+	enc  [][]byte
+}
+
+func (ks keySorter) Len() int { return len(ks.keys) }
+
+func (ks keySorter) Less(i, j int) bool { return bytes.Compare(ks.enc[i], ks.enc[j]) < 0 }
+
+func (ks keySorter) Swap(i, j int) {
+	ks.keys[i], ks.keys[j] = ks.keys[j], ks.keys[i]
+	ks.enc[i], ks.enc[j] = ks.enc[j], ks.enc[i]
+}
+
+// keyCheck checks that keys of a map being read are in canonical order.
+type keyCheck struct {
+	nm   NilMode
+	prev reflect.Value
+	enc  []byte
+	buf  bytes.Buffer
+}
+
+// next returns ErrNotCanonical if k does not follow the previous key in
+// canonical order.
+func (kc *keyCheck) next(k reflect.Value) error {
+	if isOrderedKind(k.Kind()) {
+		if kc.prev.IsValid() && !lessOrdered(kc.prev, k) {
+			return ErrNotCanonical
+		}
+		kc.prev = k
+		return nil
+	}
+	kc.buf.Reset()
+	if err := keyEncoding(&kc.buf, kc.nm, k); err != nil {
+		return err
+	}
+	if kc.prev.IsValid() && bytes.Compare(kc.enc, kc.buf.Bytes()) >= 0 {
+		return ErrNotCanonical
+	}
+	kc.prev = k
+	kc.enc = append(kc.enc[:0], kc.buf.Bytes()...)
+	return nil
+}
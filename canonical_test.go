@@ -0,0 +1,106 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type CanonicalKey struct {
+	A string
+	B [2]int
+}
+
+type CanonicalTypes struct {
+	Ints    map[int]string
+	Strings map[string]map[uint8]bool
+	Floats  map[float64]int
+	Structs map[CanonicalKey][]int
+	Arrays  map[[3]byte]int
+	Shapes  map[Shape]int
+}
+
+func (ct *CanonicalTypes) init() {
+	ct.Ints = make(map[int]string)
+	ct.Strings = make(map[string]map[uint8]bool)
+	ct.Floats = make(map[float64]int)
+	ct.Structs = make(map[CanonicalKey][]int)
+	ct.Arrays = make(map[[3]byte]int)
+	ct.Shapes = make(map[Shape]int)
+	for i := -32; i < 32; i++ {
+		ct.Ints[i] = string(rune('a' + i&15))
+		ct.Strings[string(rune('a'+i&15))+string(rune('a'+i>>2&15))] = map[uint8]bool{
+			uint8(i): true, uint8(i + 1): false,
+		}
+		ct.Floats[float64(i)/3] = i
+		ct.Structs[CanonicalKey{string(rune('a' + i&7)), [2]int{i, -i}}] = []int{i}
+		ct.Arrays[[3]byte{byte(i), byte(i >> 1), byte(i >> 2)}] = i
+		ct.Shapes[Rect{float64(i), float64(-i)}] = i
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	var first []byte
+	for i := 0; i < 8; i++ {
+		// A new value each time, so that maps are built differently.
+		var out CanonicalTypes
+		out.init()
+		buf := bytes.NewBuffer(nil)
+		enc := NewEncoderWithOptions(buf, EncoderOptions{Canonical: true})
+		if err := enc.Encode(out); err != nil {
+			t.Fatal("Encode canonical failed", err)
+		}
+		if i == 0 {
+			first = append([]byte(nil), buf.Bytes()...)
+		} else if !bytes.Equal(first, buf.Bytes()) {
+			t.Fatal("canonical encoding differs between runs")
+		}
+	}
+	var out, in CanonicalTypes
+	out.init()
+	ok, err := IsCanonical(bytes.NewReader(first), &in)
+	if err != nil || !ok {
+		t.Fatalf("IsCanonical failed: %v, %v", ok, err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatal("Encode/Decode canonical missmatch")
+	}
+	checkRoundTrip(t, EncoderOptions{Canonical: true, SelfDescribing: true},
+		DecoderOptions{Canonical: true, SelfDescribing: true}, out)
+}
+
+func TestCanonicalCheck(t *testing.T) {
+	// {2: 0, 1: 0}, keys out of order.
+	data := []byte{4, 4, 0, 2, 0}
+	var in map[int]int
+	if ok, err := IsCanonical(bytes.NewReader(data), &in); err != nil || ok {
+		t.Fatalf("IsCanonical: expected false, got %v, %v", ok, err)
+	}
+	dec := NewDecoderWithOptions(bytes.NewReader(data), DecoderOptions{Canonical: true})
+	if err := dec.Decode(&in); !errors.Is(err, ErrNotCanonical) {
+		t.Fatalf("expected ErrNotCanonical, got %v", err)
+	}
+	// Same map reads without the check.
+	if err := Read(bytes.NewReader(data), &in); err != nil || len(in) != 2 {
+		t.Fatalf("Read failed: %v, %v", in, err)
+	}
+}
+
+func TestCanonicalUnordered(t *testing.T) {
+	a, b := 1, 1
+	for _, val := range []interface{}{
+		map[float64]int{math.NaN(): 1, math.NaN(): 2},
+		map[*int]int{&a: 1, &b: 2},
+	} {
+		enc := NewEncoderWithOptions(bytes.NewBuffer(nil), EncoderOptions{Canonical: true})
+		if err := enc.Encode(val); !errors.Is(err, ErrNotCanonical) {
+			t.Fatalf("%T: expected ErrNotCanonical, got %v", val, err)
+		}
+	}
+}
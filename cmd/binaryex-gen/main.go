@@ -0,0 +1,541 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+// Command binaryex-gen generates MarshalBinaryEx and UnmarshalBinaryEx
+// methods for struct types which write and read values without reflection
+// and produce the same bytes as binaryex.Write and binaryex.Read. Encoders
+// and Decoders detect and call the generated methods.
+//
+// Usage:
+//
+//	binaryex-gen [-type T,U] [-output file] [-import path] [files]
+//
+// Files default to .go files of the package in the current directory,
+// excluding tests and the output file. Methods are generated for all
+// struct types declared in the files or for types listed by -type.
+//
+// Fields of bool, number and string types and byte slices are written
+// directly. Fields of other types, including structs with generated
+// methods, are written by calling back into the Encoder, so that codecs and
+// limits apply to them. Encoders and Decoders do not call generated methods
+// of a type if a codec is registered for a type of a field written directly.
+// Unexported fields tagged with the include option are written as well.
+// Types whose fields have tag options other than an ordinal and include or
+// with embedded pointers are skipped, as are types with BinaryMarshaler,
+// GobEncoder or TextMarshaler methods.
+//
+// It is meant to be run by go generate:
+//
+//	//go:generate binaryex-gen -type Order,Item
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	var (
+		types      = flag.String("type", "", "comma separated list of type names; default all struct types")
+		output     = flag.String("output", "", "output file name; default <package>_binaryex.go")
+		importPath = flag.String("import", "binaryex", "import path of the binaryex package")
+	)
+	flag.Parse()
+	files := flag.Args()
+	if len(files) == 0 {
+		var err error
+		if files, err = packageFiles(".", *output); err != nil {
+			fatal(err)
+		}
+	}
+	var names []string
+	if *types != "" {
+		names = strings.Split(*types, ",")
+	}
+	g, err := newGenerator(files, names, *importPath)
+	if err != nil {
+		fatal(err)
+	}
+	for _, w := range g.warnings {
+		fmt.Fprintln(os.Stderr, "binaryex-gen:", w)
+	}
+	src, err := g.generate()
+	if err != nil {
+		fatal(err)
+	}
+	if *output == "" {
+		*output = filepath.Join(filepath.Dir(files[0]), g.pkg+"_binaryex.go")
+	}
+	if err = ioutil.WriteFile(*output, src, 0644); err != nil {
+		fatal(err)
+	}
+}
+
+// fatal prints err and exits.
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "binaryex-gen:", err)
+	os.Exit(1)
+}
+
+// packageFiles returns .go files in dir excluding tests and output.
+func packageFiles(dir, output string) (files []string, err error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range matches {
+		if strings.HasSuffix(m, "_test.go") ||
+			output != "" && filepath.Clean(m) == filepath.Clean(output) {
+			continue
+		}
+		files = append(files, m)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no go files in %s", dir)
+	}
+	return
+}
+
+// codecMethods are methods that make a type encode other than by its'
+// fields.
+var codecMethods = []string{
+	"MarshalBinary", "UnmarshalBinary", "MarshalBinaryEx", "UnmarshalBinaryEx",
+	"GobEncode", "GobDecode", "MarshalText", "UnmarshalText",
+}
+
+// basicSizes holds sizes of basic number types.
+var basicSizes = map[string]int{
+	"int": 8, "int8": 1, "int16": 2, "int32": 4, "int64": 8,
+	"uint": 8, "uint8": 1, "uint16": 2, "uint32": 4, "uint64": 8,
+	"byte": 1, "rune": 4,
+}
+
+// fieldKind is the way a field is written.
+type fieldKind int
+
+const (
+	// kindValue fields are written by the reflective encoder.
+	kindValue fieldKind = iota
+	// kindBool fields are written as bools.
+	kindBool
+	// kindInt fields are written as ints.
+	kindInt
+	// kindUint fields are written as uints.
+	kindUint
+	// kindFloat32, kindFloat64, kindComplex64 and kindComplex128 fields are
+	// written as numbers of their width.
+	kindFloat32
+	kindFloat64
+	kindComplex64
+	kindComplex128
+	// kindString fields are written as strings.
+	kindString
+	// kindBytes fields are written as byte slices.
+	kindBytes
+)
+
+// field describes a struct field to generate code for.
+type field struct {
+	name    string
+	typ     string
+	kind    fieldKind
+	size    int
+	named   bool
+	ordinal int
+}
+
+// generator generates methods for types declared in a set of files.
+type generator struct {
+	// pkg is the package name.
+	pkg string
+	// qual is the qualifier of binaryex identifiers.
+	qual string
+	// importPath is the import path of binaryex.
+	importPath string
+	// specs holds declared types by name.
+	specs map[string]*ast.TypeSpec
+	// methods holds declared method names by receiver type name.
+	methods map[string]map[string]bool
+	// types holds names of types to generate methods for in order of
+	// their declaration.
+	types []string
+	// warnings holds reasons for skipping types.
+	warnings []string
+}
+
+// newGenerator parses files and selects types to generate methods for.
+// If names is empty all struct types are selected.
+func newGenerator(files, names []string, importPath string) (*generator, error) {
+	g := &generator{
+		importPath: importPath,
+		specs:      make(map[string]*ast.TypeSpec),
+		methods:    make(map[string]map[string]bool),
+	}
+	var order []string
+	fset := token.NewFileSet()
+	for _, name := range files {
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		if g.pkg == "" {
+			g.pkg = f.Name.Name
+		} else if g.pkg != f.Name.Name {
+			return nil, fmt.Errorf("files of different packages %s and %s", g.pkg, f.Name.Name)
+		}
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.GenDecl:
+				if decl.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range decl.Specs {
+					ts := spec.(*ast.TypeSpec)
+					if ts.Assign.IsValid() {
+						continue
+					}
+					g.specs[ts.Name.Name] = ts
+					order = append(order, ts.Name.Name)
+				}
+			case *ast.FuncDecl:
+				if decl.Recv == nil || len(decl.Recv.List) != 1 {
+					continue
+				}
+				recv := decl.Recv.List[0].Type
+				if star, ok := recv.(*ast.StarExpr); ok {
+					recv = star.X
+				}
+				if id, ok := recv.(*ast.Ident); ok {
+					if g.methods[id.Name] == nil {
+						g.methods[id.Name] = make(map[string]bool)
+					}
+					g.methods[id.Name][decl.Name.Name] = true
+				}
+			}
+		}
+	}
+	if g.pkg != "binaryex" {
+		g.qual = "binaryex."
+	}
+	explicit := len(names) > 0
+	if !explicit {
+		for _, name := range order {
+			if _, ok := g.specs[name].Type.(*ast.StructType); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	for _, name := range names {
+		if err := g.check(name); err != nil {
+			if explicit {
+				return nil, err
+			}
+			g.warnings = append(g.warnings, err.Error())
+			continue
+		}
+		g.types = append(g.types, name)
+	}
+	return g, nil
+}
+
+// check returns an error if methods can not be generated for type name.
+func (g *generator) check(name string) error {
+	ts, ok := g.specs[name]
+	if !ok {
+		return fmt.Errorf("type %s not found", name)
+	}
+	st, ok := ts.Type.(*ast.StructType)
+	if !ok {
+		return fmt.Errorf("type %s is not a struct", name)
+	}
+	if g.hasCodecMethods(name) {
+		return fmt.Errorf("type %s has encoding methods", name)
+	}
+	for _, f := range st.Fields.List {
+		ordinal, include, err := parseTag(f.Tag)
+		if err != nil {
+			return fmt.Errorf("type %s: %v", name, err)
+		}
+		// Embedded pointers are written with a presence marker.
+		if _, ok := f.Type.(*ast.StarExpr); ok && len(f.Names) == 0 && ordinal >= 0 &&
+			(include || ast.IsExported(embeddedName(f.Type))) {
+			return fmt.Errorf("type %s: embedded pointer %s", name, typeString(f.Type))
+		}
+	}
+	return nil
+}
+
+// hasCodecMethods returns true if type name declares any of codecMethods.
+func (g *generator) hasCodecMethods(name string) bool {
+	for _, m := range codecMethods {
+		if g.methods[name][m] {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTag returns the ordinal and the include option from a binaryex field
+// tag or an error if the tag has options generated code does not support.
+// Ordinal is -1 for skipped fields.
+func parseTag(lit *ast.BasicLit) (ordinal int, include bool, err error) {
+	if lit == nil {
+		return
+	}
+	s, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return
+	}
+	tag := reflect.StructTag(s).Get("binaryex")
+	opts := strings.Split(tag, ",")
+	switch {
+	case len(opts) > 2, len(opts) == 2 && opts[1] != "include":
+		return 0, false, fmt.Errorf("unsupported tag %q", tag)
+	case len(opts) == 2:
+		include = true
+	}
+	switch opts[0] {
+	case "":
+		return
+	case "-":
+		if include {
+			return 0, false, fmt.Errorf("invalid tag %q", tag)
+		}
+		return -1, false, nil
+	case "include":
+		if include {
+			return 0, false, fmt.Errorf("invalid tag %q", tag)
+		}
+		return 0, true, nil
+	}
+	if ordinal, err = strconv.Atoi(opts[0]); err != nil || ordinal < 1 {
+		return 0, false, fmt.Errorf("invalid tag %q", tag)
+	}
+	return
+}
+
+// fields returns fields of struct type name in the order they are written.
+func (g *generator) fields(name string) (fields []field, err error) {
+	st := g.specs[name].Type.(*ast.StructType)
+	for _, f := range st.Fields.List {
+		ordinal, include, err := parseTag(f.Tag)
+		if err != nil {
+			return nil, err
+		}
+		if ordinal < 0 {
+			continue
+		}
+		names := make([]string, 0, len(f.Names))
+		for _, id := range f.Names {
+			names = append(names, id.Name)
+		}
+		if len(names) == 0 {
+			names = append(names, embeddedName(f.Type))
+		}
+		for _, n := range names {
+			if !ast.IsExported(n) && !include {
+				continue
+			}
+			fld := field{name: n, typ: typeString(f.Type), ordinal: ordinal}
+			fld.kind, fld.size, fld.named = g.classify(f.Type)
+			fields = append(fields, fld)
+		}
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		oi, oj := fields[i].ordinal, fields[j].ordinal
+		if oi == 0 || oj == 0 {
+			return oj == 0 && oi != 0
+		}
+		return oi < oj
+	})
+	for i := 1; i < len(fields); i++ {
+		if fields[i].ordinal != 0 && fields[i].ordinal == fields[i-1].ordinal {
+			return nil, fmt.Errorf("type %s: duplicate ordinal %d", name, fields[i].ordinal)
+		}
+	}
+	return
+}
+
+// embeddedName returns the field name of an embedded field of type expr.
+func embeddedName(expr ast.Expr) string {
+	switch x := expr.(type) {
+	case *ast.StarExpr:
+		return embeddedName(x.X)
+	case *ast.SelectorExpr:
+		return x.Sel.Name
+	case *ast.Ident:
+		return x.Name
+	}
+	return ""
+}
+
+// typeString returns source of type expression expr.
+func typeString(expr ast.Expr) string {
+	var buf bytes.Buffer
+	format.Node(&buf, token.NewFileSet(), expr)
+	return buf.String()
+}
+
+// classify returns how a field of type expr is written, the size of ints
+// and uints and if it is a named type that needs a conversion.
+func (g *generator) classify(expr ast.Expr) (kind fieldKind, size int, named bool) {
+	switch x := expr.(type) {
+	case *ast.Ident:
+		return g.classifyName(x.Name)
+	case *ast.ArrayType:
+		if x.Len != nil {
+			break
+		}
+		if kind, size, named = g.classify(x.Elt); kind == kindUint && size == 1 && !named {
+			return kindBytes, 0, false
+		}
+	}
+	return kindValue, 0, false
+}
+
+// classifyName classifies a field of type name like classify.
+func (g *generator) classifyName(name string) (kind fieldKind, size int, named bool) {
+	if ts, ok := g.specs[name]; ok {
+		if g.hasCodecMethods(name) {
+			return kindValue, 0, false
+		}
+		if id, ok := ts.Type.(*ast.Ident); ok && id.Name == name {
+			return kindValue, 0, false
+		}
+		kind, size, _ = g.classify(ts.Type)
+		return kind, size, true
+	}
+	switch name {
+	case "bool":
+		return kindBool, 0, false
+	case "int", "int8", "int16", "int32", "int64", "rune":
+		return kindInt, basicSizes[name], false
+	case "uint", "uint8", "uint16", "uint32", "uint64", "byte":
+		return kindUint, basicSizes[name], false
+	case "float32":
+		return kindFloat32, 0, false
+	case "float64":
+		return kindFloat64, 0, false
+	case "complex64":
+		return kindComplex64, 0, false
+	case "complex128":
+		return kindComplex128, 0, false
+	case "string":
+		return kindString, 0, false
+	}
+	return kindValue, 0, false
+}
+
+// generate returns formatted source of generated methods.
+func (g *generator) generate() ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by binaryex-gen. DO NOT EDIT.\n\npackage %s\n\n", g.pkg)
+	if g.qual != "" {
+		fmt.Fprintf(&buf, "import %q\n\n", g.importPath)
+	}
+	for _, name := range g.types {
+		fields, err := g.fields(name)
+		if err != nil {
+			return nil, err
+		}
+		g.marshal(&buf, name, fields)
+		g.unmarshal(&buf, name, fields)
+	}
+	return format.Source(buf.Bytes())
+}
+
+// marshal writes MarshalBinaryEx method of type name.
+func (g *generator) marshal(buf *bytes.Buffer, name string, fields []field) {
+	fmt.Fprintf(buf, "// MarshalBinaryEx implements %sMarshaler.\n", g.qual)
+	fmt.Fprintf(buf, "func (x *%s) MarshalBinaryEx(e *%sEncoder) (err error) {\n", name, g.qual)
+	for _, f := range fields {
+		var call string
+		v := "x." + f.name
+		switch f.kind {
+		case kindBool:
+			call = fmt.Sprintf("e.WriteBool(%s)", conv(f, "bool", v))
+		case kindInt:
+			call = fmt.Sprintf("e.WriteInt(int64(%s), %d)", v, f.size)
+		case kindUint:
+			call = fmt.Sprintf("e.WriteUint(uint64(%s), %d)", v, f.size)
+		case kindFloat32:
+			call = fmt.Sprintf("e.WriteFloat32(%s)", conv(f, "float32", v))
+		case kindFloat64:
+			call = fmt.Sprintf("e.WriteFloat64(%s)", conv(f, "float64", v))
+		case kindComplex64:
+			call = fmt.Sprintf("e.WriteComplex64(%s)", conv(f, "complex64", v))
+		case kindComplex128:
+			call = fmt.Sprintf("e.WriteComplex128(%s)", conv(f, "complex128", v))
+		case kindString:
+			call = fmt.Sprintf("e.WriteString(%s)", conv(f, "string", v))
+		case kindBytes:
+			call = fmt.Sprintf("e.WriteBytes(%s)", conv(f, "[]byte", v))
+		default:
+			call = fmt.Sprintf("e.WriteValue(&%s)", v)
+		}
+		fmt.Fprintf(buf, "if err = %s; err != nil {\nreturn\n}\n", call)
+	}
+	buf.WriteString("return\n}\n\n")
+}
+
+// readers maps field kinds to Decoder methods and types they return.
+var readers = map[fieldKind][2]string{
+	kindBool:       {"ReadBool()", "bool"},
+	kindInt:        {"ReadInt(%d)", "int64"},
+	kindUint:       {"ReadUint(%d)", "uint64"},
+	kindFloat32:    {"ReadFloat32()", "float32"},
+	kindFloat64:    {"ReadFloat64()", "float64"},
+	kindComplex64:  {"ReadComplex64()", "complex64"},
+	kindComplex128: {"ReadComplex128()", "complex128"},
+	kindString:     {"ReadString()", "string"},
+	kindBytes:      {"ReadBytes()", "[]byte"},
+}
+
+// unmarshal writes UnmarshalBinaryEx method of type name.
+func (g *generator) unmarshal(buf *bytes.Buffer, name string, fields []field) {
+	fmt.Fprintf(buf, "// UnmarshalBinaryEx implements %sUnmarshaler.\n", g.qual)
+	fmt.Fprintf(buf, "func (x *%s) UnmarshalBinaryEx(d *%sDecoder) (err error) {\n", name, g.qual)
+	for _, f := range fields {
+		v := "x." + f.name
+		r, ok := readers[f.kind]
+		if !ok {
+			fmt.Fprintf(buf, "if err = d.ReadValue(&%s); err != nil {\nreturn\n}\n", v)
+			continue
+		}
+		call := "d." + r[0]
+		if f.size > 0 {
+			call = fmt.Sprintf(call, f.size)
+		}
+		if !f.named && f.typ == r[1] {
+			fmt.Fprintf(buf, "if %s, err = %s; err != nil {\nreturn\n}\n", v, call)
+			continue
+		}
+		fmt.Fprintf(buf, "{\nvar v %s\nif v, err = %s; err != nil {\nreturn\n}\n%s = %s(v)\n}\n",
+			r[1], call, v, f.typ)
+	}
+	buf.WriteString("return\n}\n\n")
+}
+
+// conv returns v converted to typ if field f is of a named type.
+func conv(f field, typ, v string) string {
+	if !f.named {
+		return v
+	}
+	if strings.HasPrefix(typ, "[") {
+		typ = "(" + typ + ")"
+	}
+	return typ + "(" + v + ")"
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateGolden(t *testing.T) {
+	names := []string{
+		"GenStructType", "GenBaseTypes", "GenMarshalableTypes", "GenPointerTypes",
+		"GenDeepPointerTypes", "GenNilTypes", "GenAllTypes", "GenCodecTypes",
+	}
+	g, err := newGenerator([]string{"../../generated_test.go"}, names, "binaryex")
+	if err != nil {
+		t.Fatal("newGenerator failed", err)
+	}
+	src, err := g.generate()
+	if err != nil {
+		t.Fatal("generate failed", err)
+	}
+	golden, err := ioutil.ReadFile("../../generated_gen_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, golden) {
+		t.Fatal("generated_gen_test.go is out of date, run go generate")
+	}
+}
+
+const sample = `package sample
+
+import "time"
+
+type Level int8
+
+type Blob []byte
+
+type Item struct {
+	ID    uint32 ` + "`binaryex:\"2\"`" + `
+	Name  string ` + "`binaryex:\"1\"`" + `
+	Level Level
+	Data  Blob
+	Time  time.Time
+	Skip  int ` + "`binaryex:\"-\"`" + `
+	priv  int
+	state string ` + "`binaryex:\"include\"`" + `
+}
+
+type Order struct {
+	Item
+	Items []Item
+}
+
+type Omit struct {
+	X int ` + "`binaryex:\",omitempty\"`" + `
+}
+
+type Embedded struct {
+	*Item
+}
+
+type Custom struct{ X int }
+
+func (c *Custom) MarshalBinary() ([]byte, error) { return nil, nil }
+`
+
+func TestGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binaryex-gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "sample.go")
+	if err := ioutil.WriteFile(name, []byte(sample), 0644); err != nil {
+		t.Fatal(err)
+	}
+	g, err := newGenerator([]string{name}, nil, "example.com/binaryex")
+	if err != nil {
+		t.Fatal("newGenerator failed", err)
+	}
+	if len(g.warnings) != 3 {
+		t.Fatalf("expected 3 warnings, got %v", g.warnings)
+	}
+	b, err := g.generate()
+	if err != nil {
+		t.Fatal("generate failed", err)
+	}
+	src := string(b)
+	for _, s := range []string{
+		`import "example.com/binaryex"`,
+		"func (x *Item) MarshalBinaryEx(e *binaryex.Encoder) (err error) {\n\tif err = e.WriteString(x.Name); err != nil {",
+		"e.WriteInt(int64(x.Level), 1)",
+		"e.WriteBytes(([]byte)(x.Data))",
+		"x.Data = Blob(v)",
+		"e.WriteValue(&x.Time)",
+		"e.WriteValue(&x.Item)",
+		"d.ReadValue(&x.Item)",
+		"e.WriteString(x.state)",
+	} {
+		if !strings.Contains(src, s) {
+			t.Fatalf("expected %q in:\n%s", s, src)
+		}
+	}
+	for _, s := range []string{"x.Skip", "x.priv", "*Omit", "*Custom", "*Embedded"} {
+		if strings.Contains(src, s) {
+			t.Fatalf("unexpected %q in:\n%s", s, src)
+		}
+	}
+	if _, err := newGenerator([]string{name}, []string{"Omit"}, "binaryex"); err == nil {
+		t.Fatal("expected error for unsupported type")
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"reflect"
+	"sync"
+)
+
+// EncodeFunc writes a value v of a type it was registered for by
+// RegisterCodec or Encoder.RegisterCodec to e using Encoder Write methods.
+// v is not always addressable.
+type EncodeFunc = func(e *Encoder, v reflect.Value) error
+
+// DecodeFunc reads a value into a settable v of a type it was registered
+// for by RegisterCodec or Decoder.RegisterCodec from d using Decoder Read
+// methods.
+type DecodeFunc = func(d *Decoder, v reflect.Value) error
+
+// codec holds functions registered for a type by RegisterCodec.
+type codec struct {
+	enc EncodeFunc
+	dec DecodeFunc
+}
+
+// codecs holds codecs registered by RegisterCodec.
+var codecs = struct {
+	sync.RWMutex
+	types map[reflect.Type]codec
+}{
+	types: make(map[reflect.Type]codec),
+}
+
+// RegisterCodec registers functions that write and read values of type typ
+// in place of marshaler methods of typ or reflection, for types that can
+// not be given methods. Codecs registered on an Encoder or a Decoder take
+// precedence.
+//
+// The same codec must be used by both the writing and the reading side. In
+// a self describing stream values are written as byte slices holding the
+// output of enc written without self description, otherwise as written by
+// enc. Values written by codecs can not be skipped by Decoder.Skip outside
+// of a self describing stream.
+//
+// RegisterCodec panics if typ is nil, a pointer or an interface type, if
+// enc or dec is nil or if values of typ were already written or read. It is
+// intended to be called from init functions.
+func RegisterCodec(typ reflect.Type, enc EncodeFunc, dec DecodeFunc) {
+	checkCodecType(typ)
+	if enc == nil || dec == nil {
+		panic("binaryex: attempt to register nil codec")
+	}
+	_, encoded := encPlans.Load(typ)
+	_, decoded := decPlans.Load(typ)
+	_, skipped := skipPlans.Load(typ)
+	if encoded || decoded || skipped {
+		panic("binaryex: registering codec for " + typ.String() + " after use")
+	}
+	codecs.Lock()
+	codecs.types[typ] = codec{enc, dec}
+	codecs.Unlock()
+}
+
+// checkCodecType panics if a codec can not be registered for type t.
+func checkCodecType(t reflect.Type) {
+	if t == nil {
+		panic("binaryex: attempt to register codec for nil type")
+	}
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		panic("binaryex: attempt to register codec for " + t.String())
+	}
+}
+
+// registeredCodec returns the codec registered for type t.
+func registeredCodec(t reflect.Type) (c codec, ok bool) {
+	codecs.RLock()
+	c, ok = codecs.types[t]
+	codecs.RUnlock()
+	return
+}
+
+// RegisterCodec registers enc to write values of type typ by e in place of
+// a codec registered by RegisterCodec, marshaler methods of typ or
+// reflection. A nil enc removes it. It must be called before values of typ
+// are encoded. It panics if typ is nil, a pointer or an interface type.
+func (e *Encoder) RegisterCodec(typ reflect.Type, enc EncodeFunc) {
+	checkCodecType(typ)
+	if enc == nil {
+		delete(e.codecs, typ)
+		return
+	}
+	if e.codecs == nil {
+		e.codecs = make(map[reflect.Type]EncodeFunc)
+	}
+	e.codecs[typ] = enc
+}
+
+// RegisterCodec registers dec to read values of type typ by d in place of
+// a codec registered by RegisterCodec, unmarshaler methods of typ or
+// reflection. A nil dec removes it. It must be called before values of typ
+// are decoded. It panics if typ is nil, a pointer or an interface type.
+func (d *Decoder) RegisterCodec(typ reflect.Type, dec DecodeFunc) {
+	checkCodecType(typ)
+	if dec == nil {
+		delete(d.codecs, typ)
+		return
+	}
+	if d.codecs == nil {
+		d.codecs = make(map[reflect.Type]DecodeFunc)
+	}
+	d.codecs[typ] = dec
+}
+
+// codecFor returns the encoding function registered for type t with e or
+// globally or nil if there is none.
+func (e *Encoder) codecFor(t reflect.Type) EncodeFunc {
+	if enc, ok := e.codecs[t]; ok {
+		return enc
+	}
+	c, _ := registeredCodec(t)
+	return c.enc
+}
+
+// codecFor returns the decoding function registered for type t with d or
+// globally or nil if there is none.
+func (d *Decoder) codecFor(t reflect.Type) DecodeFunc {
+	if dec, ok := d.codecs[t]; ok {
+		return dec
+	}
+	c, _ := registeredCodec(t)
+	return c.dec
+}
+
+// withCodecs wraps plan f of type t to use codecs registered with an
+// Encoder.
+func withCodecs(t reflect.Type, f encFunc) encFunc {
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		return f
+	}
+	return func(e *Encoder, v reflect.Value) error {
+		if e.codecs != nil {
+			if enc, ok := e.codecs[t]; ok {
+				return e.encodeCodec(enc, v)
+			}
+		}
+		return f(e, v)
+	}
+}
+
+// encodeCodec writes v using enc. In a self describing stream the output
+// of enc is written as a byte slice by an Encoder that does not describe
+// values and uses default Packed and IntEncoding options.
+func (e *Encoder) encodeCodec(enc EncodeFunc, v reflect.Value) error {
+	if !e.opts.SelfDescribing {
+		return enc(e, v)
+	}
+	sw := &sliceWriter{}
+	sub := &Encoder{w: sw, opts: e.opts, codecs: e.codecs}
+	sub.opts.SelfDescribing = false
+	sub.opts.Packed = false
+	sub.opts.IntEncoding = IntZigZag
+	if err := enc(sub, v); err != nil {
+		return err
+	}
+	return e.writeBytes(sw.p)
+}
+
+// withDecodeCodecs wraps plan f of type t to use codecs registered with a
+// Decoder.
+func withDecodeCodecs(t reflect.Type, f decFunc) decFunc {
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		return f
+	}
+	return func(d *Decoder, v reflect.Value) error {
+		if d.codecs != nil {
+			if dec, ok := d.codecs[t]; ok {
+				return d.decodeCodec(dec, v)
+			}
+		}
+		return f(d, v)
+	}
+}
+
+// decodeCodec reads v using dec. In a self describing stream a byte slice
+// is read and dec reads from it as written by encodeCodec.
+func (d *Decoder) decodeCodec(dec DecodeFunc, v reflect.Value) error {
+	if !d.opts.SelfDescribing {
+		return dec(d, v)
+	}
+	p, err := d.readBytes()
+	if err != nil {
+		return err
+	}
+	sub := NewSliceDecoder(p, d.opts)
+	sub.opts.SelfDescribing = false
+	sub.opts.Packed = false
+	sub.opts.IntEncoding = IntZigZag
+	sub.codecs = d.codecs
+	sub.begin()
+	sub.depth = d.depth
+	return dec(sub, v)
+}
+
+// withSkipCodecs wraps plan f of type t to fail on types with codecs
+// registered with a Decoder.
+func withSkipCodecs(t reflect.Type, f skipFunc) skipFunc {
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		return f
+	}
+	return func(d *Decoder) error {
+		if d.codecs != nil {
+			if _, ok := d.codecs[t]; ok {
+				return ErrUnsupportedValue
+			}
+		}
+		return f(d)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// CodecPoint is written by a registered codec as a single uint.
+type CodecPoint struct {
+	X, Y uint32
+}
+
+// CodecEnum has marshaler methods overriden by a registered codec.
+type CodecEnum TextEnum
+
+func (ce CodecEnum) MarshalText() ([]byte, error) {
+	return TextEnum(ce).MarshalText()
+}
+
+func (ce *CodecEnum) UnmarshalText(p []byte) error {
+	return (*TextEnum)(ce).UnmarshalText(p)
+}
+
+func init() {
+	RegisterCodec(reflect.TypeOf(CodecPoint{}),
+		func(e *Encoder, v reflect.Value) error {
+			p := v.Interface().(CodecPoint)
+			return e.WriteUint(uint64(p.X)<<32|uint64(p.Y), 8)
+		},
+		func(d *Decoder, v reflect.Value) error {
+			u, err := d.ReadUint(8)
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(CodecPoint{uint32(u >> 32), uint32(u)}))
+			return nil
+		})
+	RegisterCodec(reflect.TypeOf(CodecEnum(0)),
+		func(e *Encoder, v reflect.Value) error {
+			return e.WriteInt(v.Int(), 8)
+		},
+		func(d *Decoder, v reflect.Value) error {
+			n, err := d.ReadInt(8)
+			v.SetInt(n)
+			return err
+		})
+}
+
+type CodecTypes struct {
+	Point  CodecPoint
+	Points []CodecPoint
+	Enum   CodecEnum
+	URL    url.URL
+	PURL   *url.URL
+	After  string
+}
+
+func (ct *CodecTypes) init() {
+	ct.Point = CodecPoint{1, 2}
+	ct.Points = []CodecPoint{{3, 4}, {5, 6}}
+	ct.Enum = 7
+	ct.URL = url.URL{Scheme: "https", Host: "example.com", Path: "/a"}
+	ct.PURL = &url.URL{Scheme: "file", Path: "/tmp"}
+	ct.After = "after"
+}
+
+func encodeURL(e *Encoder, v reflect.Value) error {
+	u := v.Interface().(url.URL)
+	return e.WriteString(u.String())
+}
+
+func decodeURL(d *Decoder, v reflect.Value) error {
+	s, err := d.ReadString()
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.ValueOf(*u))
+	return nil
+}
+
+func TestCodec(t *testing.T) {
+	var out CodecTypes
+	out.init()
+	for _, sd := range []bool{false, true} {
+		buf := bytes.NewBuffer(nil)
+		enc := NewEncoderWithOptions(buf, EncoderOptions{SelfDescribing: sd})
+		enc.RegisterCodec(reflect.TypeOf(url.URL{}), encodeURL)
+		if err := enc.Encode(out); err != nil {
+			t.Fatal("Encode failed", err)
+		}
+		if err := enc.Encode(out.URL); err != nil {
+			t.Fatal("Encode failed", err)
+		}
+		dec := NewDecoderWithOptions(buf, DecoderOptions{SelfDescribing: sd})
+		dec.RegisterCodec(reflect.TypeOf(url.URL{}), decodeURL)
+		var in CodecTypes
+		if err := dec.Decode(&in); err != nil {
+			t.Fatal("Decode failed", err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("%t: Encode/Decode missmatch: in\n%v, out:\n%v\n", sd, in, out)
+		}
+		var u url.URL
+		if err := dec.Decode(&u); err != nil {
+			t.Fatal("Decode failed", err)
+		}
+		if u != out.URL {
+			t.Fatalf("%t: Encode/Decode missmatch: %v", sd, u)
+		}
+	}
+}
+
+func TestCodecBytes(t *testing.T) {
+	data, err := Marshal(struct {
+		P CodecPoint
+		E CodecEnum
+	}{CodecPoint{1, 2}, -1})
+	if err != nil {
+		t.Fatal("Marshal failed", err)
+	}
+	expected := []byte{0x82, 0x80, 0x80, 0x80, 0x10, 0x01}
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("expected % x, got % x", expected, data)
+	}
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	enc.RegisterCodec(reflect.TypeOf(CodecEnum(0)), func(e *Encoder, v reflect.Value) error {
+		return e.WriteString("enum")
+	})
+	if err := enc.Encode(CodecEnum(1)); err != nil {
+		t.Fatal("Encode failed", err)
+	}
+	enc.RegisterCodec(reflect.TypeOf(CodecEnum(0)), nil)
+	if err := enc.Encode(CodecEnum(1)); err != nil {
+		t.Fatal("Encode failed", err)
+	}
+	if expected := []byte{8, 'e', 'n', 'u', 'm', 2}; !bytes.Equal(buf.Bytes(), expected) {
+		t.Fatalf("expected % x, got % x", expected, buf.Bytes())
+	}
+}
+
+func TestCodecSkip(t *testing.T) {
+	var out CodecTypes
+	out.init()
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoderWithOptions(buf, EncoderOptions{SelfDescribing: true})
+	enc.RegisterCodec(reflect.TypeOf(url.URL{}), encodeURL)
+	if err := enc.Encode(out); err != nil {
+		t.Fatal("Encode failed", err)
+	}
+	if err := enc.Encode(out.After); err != nil {
+		t.Fatal("Encode failed", err)
+	}
+	dec := NewDecoderWithOptions(buf, DecoderOptions{SelfDescribing: true})
+	if err := dec.Skip(nil); err != nil {
+		t.Fatal("Skip failed", err)
+	}
+	var after string
+	if err := dec.Decode(&after); err != nil {
+		t.Fatal("Decode failed", err)
+	}
+	if after != out.After {
+		t.Fatalf("expected %q, got %q", out.After, after)
+	}
+
+	buf.Reset()
+	if err := Write(buf, out.Point); err != nil {
+		t.Fatal("Write failed", err)
+	}
+	dec = NewDecoder(buf)
+	if err := dec.Skip(reflect.TypeOf(out.Point)); !errors.Is(err, ErrUnsupportedValue) {
+		t.Fatalf("expected ErrUnsupportedValue, got %v", err)
+	}
+}
+
+func TestRegisterCodecInvalid(t *testing.T) {
+	enc := func(e *Encoder, v reflect.Value) error { return nil }
+	dec := func(d *Decoder, v reflect.Value) error { return nil }
+	for _, typ := range []reflect.Type{
+		nil,
+		reflect.TypeOf(&CodecPoint{}),
+		reflect.TypeOf((*error)(nil)).Elem(),
+		reflect.TypeOf(BaseTypes{}),
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("RegisterCodec of %v did not panic", typ)
+				}
+			}()
+			if typ == reflect.TypeOf(BaseTypes{}) {
+				if _, err := Marshal(BaseTypes{}); err != nil {
+					t.Fatal("Marshal failed", err)
+				}
+			}
+			RegisterCodec(typ, enc, dec)
+		}()
+	}
+}
@@ -0,0 +1,1018 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"sync"
+)
+
+// byteReader is an io.Reader that can also read single bytes.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// countingReader counts bytes read from a byteReader or from a byte slice
+// and fails reads past a limit.
+type countingReader struct {
+	r byteReader
+	// p, if not nil, is the data read instead of r. Bytes up to n were
+	// read.
+	p []byte
+	// n is the number of bytes read.
+	n int64
+	// limit is the value of n past which reads fail, 0 for no limit.
+	limit int64
+}
+
+// Read implements io.Reader.
+func (cr *countingReader) Read(p []byte) (n int, err error) {
+	if cr.limit > 0 {
+		if cr.n >= cr.limit {
+			return 0, ErrLimitExceeded
+		}
+		if rem := cr.limit - cr.n; int64(len(p)) > rem {
+			p = p[:rem]
+		}
+	}
+	if cr.p != nil {
+		if cr.n >= int64(len(cr.p)) {
+			return 0, io.EOF
+		}
+		n = copy(p, cr.p[cr.n:])
+	} else {
+		n, err = cr.r.Read(p)
+	}
+	cr.n += int64(n)
+	return
+}
+
+// ReadByte implements io.ByteReader.
+func (cr *countingReader) ReadByte() (b byte, err error) {
+	if cr.limit > 0 && cr.n >= cr.limit {
+		return 0, ErrLimitExceeded
+	}
+	if cr.p != nil {
+		if cr.n >= int64(len(cr.p)) {
+			return 0, io.EOF
+		}
+		b = cr.p[cr.n]
+	} else if b, err = cr.r.ReadByte(); err != nil {
+		return
+	}
+	cr.n++
+	return
+}
+
+// window returns unread bytes of a byte slice reader up to the limit and
+// the error to return if more bytes than the window holds are required.
+func (cr *countingReader) window() ([]byte, error) {
+	p := cr.p[cr.n:]
+	if cr.limit > 0 && int64(len(p)) > cr.limit-cr.n {
+		return p[:cr.limit-cr.n], ErrLimitExceeded
+	}
+	if len(p) == 0 {
+		return p, io.EOF
+	}
+	return p, io.ErrUnexpectedEOF
+}
+
+// next returns next n bytes of a byte slice reader without copying them.
+func (cr *countingReader) next(n int) ([]byte, error) {
+	p, err := cr.window()
+	if len(p) < n {
+		return nil, err
+	}
+	cr.n += int64(n)
+	return p[:n:n], nil
+}
+
+// varint reads a VarInt from a byte slice reader.
+func (cr *countingReader) varint() (int64, error) {
+	p, err := cr.window()
+	x, n := binary.Varint(p)
+	if n <= 0 {
+		if n < 0 {
+			return 0, ErrUnexpected
+		}
+		return 0, err
+	}
+	cr.n += int64(n)
+	return x, nil
+}
+
+// uvarint reads an UVarInt from a byte slice reader.
+func (cr *countingReader) uvarint() (uint64, error) {
+	p, err := cr.window()
+	x, n := binary.Uvarint(p)
+	if n <= 0 {
+		if n < 0 {
+			return 0, ErrUnexpected
+		}
+		return 0, err
+	}
+	cr.n += int64(n)
+	return x, nil
+}
+
+// maxPrealloc is the maximum number of bytes allocated for a string, slice
+// or map before its' data is read.
+const maxPrealloc = 64 << 10
+
+// Decoder reads values from an input stream.
+//
+// Decoder compiles a decoding plan for each type it encounters once and
+// caches it for the lifetime of the program so decoding a stream of values
+// of the same type does not repeat the reflection work on each value.
+//
+// If the reader given to NewDecoder does not implement io.ByteReader it is
+// wrapped in a bufio.Reader and the Decoder may read data from it beyond
+// the values requested.
+//
+// A Decoder reading untrusted input should set limits in DecoderOptions.
+type Decoder struct {
+	r     countingReader
+	rbw   readByteWrapper
+	buf   [16]byte
+	opts  DecoderOptions
+	types []reflect.Type
+	depth int
+	start int64
+	// refs holds pointers read in References mode by id.
+	refs []reflect.Value
+	// scratch is a buffer for packed arrays and slices.
+	scratch []byte
+	// codecs holds codecs registered with the Decoder.
+	codecs map[reflect.Type]DecodeFunc
+
+	// self describing stream state.
+	started bool
+	defs    []*wireType
+	ifaces  []wireIface
+	matches map[wireMatchKey]*wireMatch
+}
+
+// NewDecoder returns a new Decoder that reads from r using
+// DefaultDecoderOptions.
+func NewDecoder(r io.Reader) *Decoder {
+	return NewDecoderWithOptions(r, DefaultDecoderOptions)
+}
+
+// NewDecoderWithOptions returns a new Decoder that reads from r using opts.
+func NewDecoderWithOptions(r io.Reader, opts DecoderOptions) *Decoder {
+	d := &Decoder{opts: opts}
+	if br, ok := r.(byteReader); ok || r == nil {
+		d.r.r = br
+	} else {
+		d.r.r = bufio.NewReader(r)
+	}
+	return d
+}
+
+// NewSliceDecoder returns a new Decoder that reads from p using opts. It
+// reads VarInts and raw bytes directly from p and can share byte slices it
+// reads with p if AliasBytes option is set. Once p is consumed the Decoder
+// returns io.EOF.
+func NewSliceDecoder(p []byte, opts DecoderOptions) *Decoder {
+	d := &Decoder{opts: opts}
+	d.r.p = nonNil(p)
+	return d
+}
+
+// nonNil returns p or an empty slice if p is nil.
+func nonNil(p []byte) []byte {
+	if p == nil {
+		return []byte{}
+	}
+	return p
+}
+
+// Decode reads the next value from the stream and stores it into val which
+// must be a pointer or returns an error if one occured. See Read for details.
+func (d *Decoder) Decode(val interface{}) error {
+	return d.DecodeValue(reflect.Indirect(reflect.ValueOf(val)))
+}
+
+// DecodeValue reads the next value from the stream and stores it into v
+// which must be addressable or returns an error if one occured.
+//
+// If the stream ends before the value io.EOF is returned. Other errors are
+// returned as a *DecodeError describing where reading failed, wrapping
+// io.ErrUnexpectedEOF if the stream ended while the value was being read.
+func (d *Decoder) DecodeValue(v reflect.Value) error {
+	if err := checkArg(v, true); err != nil {
+		return d.decodeError(err, valueType(v))
+	}
+	return d.decodeValue(v, nil)
+}
+
+// decodeValue reads a top level value into settable v using plan f
+// compiled for its' type or a cached plan if f is nil.
+func (d *Decoder) decodeValue(v reflect.Value, f decFunc) error {
+	return d.decodeError(d.readValue(v, f), v.Type())
+}
+
+// readValue reads a top level value for decodeValue. Panics are recovered
+// and returned as errors.
+func (d *Decoder) readValue(v reflect.Value, f decFunc) (err error) {
+	defer recoverError(&err)
+	if !d.hasReader() {
+		return ErrInvalidArgument
+	}
+	if f == nil {
+		f = decPlanFor(v.Type())
+	}
+	d.begin()
+	if !d.opts.SelfDescribing {
+		return f(d, v)
+	}
+	if err = d.readHeader(); err != nil {
+		return
+	}
+	var wt *wireType
+	if wt, err = d.readWireType(); err != nil {
+		return
+	}
+	return d.decodeWire(wt, v)
+}
+
+// decodeRaw reads a top level value into settable v using plan f without
+// self description. Panics are recovered and returned as errors.
+func (d *Decoder) decodeRaw(v reflect.Value, f decFunc) (err error) {
+	defer recoverError(&err)
+	if !d.hasReader() {
+		return ErrInvalidArgument
+	}
+	return f(d, v)
+}
+
+// hasReader returns true if d was given a reader or a slice to read from.
+func (d *Decoder) hasReader() bool {
+	return d.r.r != nil || d.r.p != nil
+}
+
+// decoderPool holds unbuffered Decoders used by package level functions.
+var decoderPool = sync.Pool{
+	New: func() interface{} { return &Decoder{} },
+}
+
+// getDecoder returns a Decoder reading from r that does not read beyond the
+// values requested.
+func getDecoder(r io.Reader) *Decoder {
+	d := decoderPool.Get().(*Decoder)
+	d.opts = DefaultDecoderOptions
+	if br, ok := r.(byteReader); ok || r == nil {
+		d.r.r = br
+	} else {
+		d.rbw.Reader = r
+		d.r.r = &d.rbw
+	}
+	d.r.n = 0
+	d.begin()
+	return d
+}
+
+// getSliceDecoder returns a Decoder reading from p.
+func getSliceDecoder(p []byte) *Decoder {
+	d := decoderPool.Get().(*Decoder)
+	d.opts = DefaultDecoderOptions
+	d.r.p = nonNil(p)
+	d.r.n = 0
+	d.begin()
+	return d
+}
+
+// putDecoder returns d to the pool.
+func putDecoder(d *Decoder) {
+	d.r.r = nil
+	d.r.p = nil
+	d.rbw.Reader = nil
+	d.types = d.types[:0]
+	d.resetRefs()
+	d.started = false
+	d.defs = d.defs[:0]
+	d.ifaces = d.ifaces[:0]
+	d.matches = nil
+	decoderPool.Put(d)
+}
+
+// begin resets per value limits and references before reading a top level
+// value.
+func (d *Decoder) begin() {
+	d.start = d.r.n
+	d.depth = 0
+	d.resetRefs()
+	d.r.limit = 0
+	if d.opts.MaxTotalBytes > 0 {
+		d.r.limit = d.r.n + d.opts.MaxTotalBytes
+	}
+}
+
+// enter increases the nesting depth or returns ErrLimitExceeded if
+// MaxDepth is exceeded. A successful enter must be followed by a leave.
+func (d *Decoder) enter() error {
+	d.depth++
+	if d.opts.MaxDepth > 0 && d.depth > d.opts.MaxDepth {
+		return ErrLimitExceeded
+	}
+	return nil
+}
+
+// maxWireDepth is the maximum nesting depth of values read by a wire type
+// descriptor if MaxDepth option does not limit it. A descriptor can refer
+// to itself through kinds read as no bytes at all, like a pointer with
+// NilLegacy mode, which would otherwise recurse until the stack overflows.
+const maxWireDepth = 1 << 16
+
+// enterWire is enter for values read by a wire type descriptor, limited to
+// maxWireDepth if MaxDepth is 0.
+func (d *Decoder) enterWire() error {
+	if err := d.enter(); err != nil {
+		return err
+	}
+	if d.opts.MaxDepth <= 0 && d.depth > maxWireDepth {
+		return ErrLimitExceeded
+	}
+	return nil
+}
+
+// leave decreases the nesting depth.
+func (d *Decoder) leave() {
+	d.depth--
+}
+
+// maxEmptyElems is the maximum number of elements of a slice, an array or
+// a map read as no bytes at all, like empty structs, if MaxSliceLen or
+// MaxMapLen option does not limit their number.
+const maxEmptyElems = 1 << 20
+
+// elemCounter counts elements of a slice, an array or a map that were read
+// as no bytes at all, so that a hostile length can not make reading loop
+// for long without reading anything.
+type elemCounter struct {
+	// n is the number of bytes read by the Decoder after the last element.
+	n int64
+	// empty is the number of elements read as no bytes.
+	empty int
+	// max is the maximum number of elements read as no bytes.
+	max int
+}
+
+// elemCounter returns an elemCounter for a slice, an array or a map whose
+// length is limited to max elements, 0 for no limit.
+func (d *Decoder) elemCounter(max int) elemCounter {
+	if max <= 0 {
+		max = maxEmptyElems
+	}
+	return elemCounter{n: d.r.n, max: max}
+}
+
+// next is called after each element is read and returns ErrLimitExceeded
+// if too many elements were read as no bytes.
+func (ec *elemCounter) next(d *Decoder) error {
+	if d.r.n != ec.n {
+		ec.n = d.r.n
+		return nil
+	}
+	if ec.empty++; ec.empty > ec.max {
+		return ErrLimitExceeded
+	}
+	return nil
+}
+
+// readFull reads exactly len(p) bytes into p.
+func (d *Decoder) readFull(p []byte) (err error) {
+	_, err = io.ReadFull(&d.r, p)
+	return
+}
+
+// readN reads n bytes. The buffer is grown as data is read so that no
+// more than maxPrealloc bytes are allocated ahead of data actually read.
+func (d *Decoder) readN(n int) (p []byte, err error) {
+	if d.r.p != nil {
+		var b []byte
+		if b, err = d.r.next(n); err != nil {
+			return nil, err
+		}
+		return append(make([]byte, 0, n), b...), nil
+	}
+	if n <= maxPrealloc {
+		p = make([]byte, n)
+		return p, d.readFull(p)
+	}
+	p = make([]byte, 0, maxPrealloc)
+	for len(p) < n {
+		if len(p) == cap(p) {
+			c := 2 * cap(p)
+			if c > n {
+				c = n
+			}
+			np := make([]byte, len(p), c)
+			copy(np, p)
+			p = np
+		}
+		if err = d.readFull(p[len(p):cap(p)]); err != nil {
+			return nil, err
+		}
+		p = p[:cap(p)]
+	}
+	return
+}
+
+// readAlias reads n bytes like readN but returns them without copying if
+// AliasBytes option is set and the Decoder reads from a byte slice.
+func (d *Decoder) readAlias(n int) ([]byte, error) {
+	if d.opts.AliasBytes && d.r.p != nil {
+		return d.r.next(n)
+	}
+	return d.readN(n)
+}
+
+// readVarint reads a VarInt.
+func (d *Decoder) readVarint() (int64, error) {
+	if d.r.p != nil {
+		return d.r.varint()
+	}
+	return binary.ReadVarint(&d.r)
+}
+
+// readUvarint reads an UVarInt.
+func (d *Decoder) readUvarint() (uint64, error) {
+	if d.r.p != nil {
+		return d.r.uvarint()
+	}
+	return binary.ReadUvarint(&d.r)
+}
+
+// readLen reads a string, slice or map length prefix and checks it against
+// max if it is greater than 0.
+func (d *Decoder) readLen(max int) (int, error) {
+	l, err := d.readVarint()
+	if err != nil {
+		return 0, err
+	}
+	if l < 0 || int64(int(l)) != l {
+		return 0, ErrUnexpected
+	}
+	if max > 0 && int(l) > max {
+		return 0, ErrLimitExceeded
+	}
+	return int(l), nil
+}
+
+// readNilLen reads a slice or map length prefix like readLen and returns -1
+// if a nil value was written in NilPreserve mode.
+func (d *Decoder) readNilLen(max int) (int, error) {
+	l, err := d.readVarint()
+	if err != nil {
+		return 0, err
+	}
+	if l == -1 && d.opts.NilMode == NilPreserve {
+		return -1, nil
+	}
+	if l < 0 || int64(int(l)) != l {
+		return 0, ErrUnexpected
+	}
+	if max > 0 && int(l) > max {
+		return 0, ErrLimitExceeded
+	}
+	return int(l), nil
+}
+
+// preallocLen returns the number of elements of size to allocate for a
+// slice of l elements before they are read.
+func preallocLen(l int, size uintptr) int {
+	if size > 0 && uintptr(l) > maxPrealloc/size {
+		return int(maxPrealloc / size)
+	}
+	return l
+}
+
+// growSlice returns slice s grown to hold at least i+1 elements but no more
+// than l elements.
+func growSlice(s reflect.Value, i, l int) reflect.Value {
+	if i < s.Len() {
+		return s
+	}
+	n := 2 * s.Len()
+	if n <= i {
+		n = i + 1
+	}
+	if n > l {
+		n = l
+	}
+	ns := reflect.MakeSlice(s.Type(), n, n)
+	reflect.Copy(ns, s)
+	return ns
+}
+
+// readBool reads a single byte bool.
+func (d *Decoder) readBool() (bool, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return false, err
+	}
+	switch b {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	}
+	return false, ErrUnexpected
+}
+
+// readFloat reads a LittleEndian float64.
+func (d *Decoder) readFloat() (float64, error) {
+	if err := d.readFull(d.buf[:8]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(d.buf[:])), nil
+}
+
+// readFloat32 reads a LittleEndian float32.
+func (d *Decoder) readFloat32() (float32, error) {
+	if err := d.readFull(d.buf[:4]); err != nil {
+		return 0, err
+	}
+	return math.Float32frombits(binary.LittleEndian.Uint32(d.buf[:])), nil
+}
+
+// readComplex64 reads a LittleEndian complex64.
+func (d *Decoder) readComplex64() (complex64, error) {
+	if err := d.readFull(d.buf[:8]); err != nil {
+		return 0, err
+	}
+	return complex(
+		math.Float32frombits(binary.LittleEndian.Uint32(d.buf[:])),
+		math.Float32frombits(binary.LittleEndian.Uint32(d.buf[4:])),
+	), nil
+}
+
+// readComplex reads a LittleEndian complex128.
+func (d *Decoder) readComplex() (complex128, error) {
+	if err := d.readFull(d.buf[:16]); err != nil {
+		return 0, err
+	}
+	return complex(
+		math.Float64frombits(binary.LittleEndian.Uint64(d.buf[:])),
+		math.Float64frombits(binary.LittleEndian.Uint64(d.buf[8:])),
+	), nil
+}
+
+// readString reads a length prefixed string.
+func (d *Decoder) readString() (string, error) {
+	l, err := d.readLen(d.opts.MaxStringLen)
+	if err != nil || l == 0 {
+		return "", err
+	}
+	if d.r.p != nil {
+		buf, err := d.r.next(l)
+		return string(buf), err
+	}
+	buf, err := d.readN(l)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// readBytes reads a length prefixed byte slice written by writeBytes.
+func (d *Decoder) readBytes() ([]byte, error) {
+	l, err := d.readLen(d.opts.MaxSliceLen)
+	if err != nil {
+		return nil, err
+	}
+	return d.readAlias(l)
+}
+
+// decFunc is a compiled decoding plan for a type.
+type decFunc func(d *Decoder, v reflect.Value) error
+
+// decPlans caches compiled decoding plans, map[reflect.Type]decFunc.
+var decPlans sync.Map
+
+// decPlanFor returns a cached decoding plan for type t, compiling it first if
+// required.
+func decPlanFor(t reflect.Type) decFunc {
+	if fi, ok := decPlans.Load(t); ok {
+		return fi.(decFunc)
+	}
+	// Store an indirect plan first so recursive types resolve to it while
+	// the real plan is being compiled.
+	var (
+		wg sync.WaitGroup
+		f  decFunc
+	)
+	wg.Add(1)
+	fi, loaded := decPlans.LoadOrStore(t, decFunc(func(d *Decoder, v reflect.Value) error {
+		wg.Wait()
+		return f(d, v)
+	}))
+	if loaded {
+		return fi.(decFunc)
+	}
+	f = newDecFunc(t)
+	wg.Done()
+	decPlans.Store(t, f)
+	return f
+}
+
+// newDecFunc compiles a decoding plan for type t which uses a codec
+// registered for t or unmarshaler methods of t if it has any.
+func newDecFunc(t reflect.Type) decFunc {
+	if c, ok := registeredCodec(t); ok {
+		return withDecodeCodecs(t, func(d *Decoder, v reflect.Value) error {
+			return d.decodeCodec(c.dec, v)
+		})
+	}
+	if ms := marshalers(t, false); ms != 0 {
+		return withDecodeCodecs(t, newMarshalerDecoder(t, ms, newReflectDecFunc(t)))
+	}
+	return withDecodeCodecs(t, newReflectDecFunc(t))
+}
+
+// newReflectDecFunc compiles a decoding plan for type t that reflects on
+// its' values.
+func newReflectDecFunc(t reflect.Type) decFunc {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return newPtrDecoder(t)
+	case reflect.Interface:
+		return decInterface
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return decBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return decInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		return decUint
+	case reflect.Float32, reflect.Float64:
+		return decFloat
+	case reflect.Complex64, reflect.Complex128:
+		return decComplex
+	case reflect.String:
+		return decString
+	case reflect.Array:
+		return newArrayDecoder(t)
+	case reflect.Slice:
+		return newSliceDecoder(t)
+	case reflect.Map:
+		return newMapDecoder(t)
+	case reflect.Struct:
+		return newStructDecoder(t)
+	}
+	return decUnsupported
+}
+
+func decUnsupported(d *Decoder, v reflect.Value) error {
+	return ErrUnsupportedValue
+}
+
+func decBool(d *Decoder, v reflect.Value) error {
+	b, err := d.readBool()
+	if err != nil {
+		return err
+	}
+	v.SetBool(b)
+	return nil
+}
+
+func decInt(d *Decoder, v reflect.Value) error {
+	n, err := d.readInt(v.Kind(), d.opts.IntEncoding)
+	if err != nil {
+		return err
+	}
+	v.SetInt(n)
+	return nil
+}
+
+func decUint(d *Decoder, v reflect.Value) error {
+	n, err := d.readUint(v.Kind(), d.opts.IntEncoding)
+	if err != nil {
+		return err
+	}
+	v.SetUint(n)
+	return nil
+}
+
+// decFloat reads a float written by encFloat.
+func decFloat(d *Decoder, v reflect.Value) error {
+	if v.Kind() == reflect.Float32 && !d.opts.WideFloats {
+		n, err := d.readFloat32()
+		if err != nil {
+			return err
+		}
+		v.SetFloat(float64(n))
+		return nil
+	}
+	n, err := d.readFloat()
+	if err != nil {
+		return err
+	}
+	v.SetFloat(n)
+	return nil
+}
+
+// decComplex reads a complex number written by encComplex.
+func decComplex(d *Decoder, v reflect.Value) error {
+	if v.Kind() == reflect.Complex64 && !d.opts.WideFloats {
+		n, err := d.readComplex64()
+		if err != nil {
+			return err
+		}
+		v.SetComplex(complex128(n))
+		return nil
+	}
+	n, err := d.readComplex()
+	if err != nil {
+		return err
+	}
+	v.SetComplex(n)
+	return nil
+}
+
+func decString(d *Decoder, v reflect.Value) error {
+	s, err := d.readString()
+	if err != nil {
+		return err
+	}
+	v.SetString(s)
+	return nil
+}
+
+// newPtrDecoder returns a plan that allocates a new value for a pointer
+// and reads into it. In NilPreserve mode the pointer is set to nil instead
+// if a nil pointer was written. If References option is set the pointer is
+// read by readRef instead.
+func newPtrDecoder(t reflect.Type) decFunc {
+	et := t.Elem()
+	elem := decPlanFor(et)
+	return func(d *Decoder, v reflect.Value) error {
+		if err := d.enter(); err != nil {
+			return err
+		}
+		if d.opts.References {
+			if err := d.readRef(v, elem); err != nil {
+				return err
+			}
+			d.leave()
+			return nil
+		}
+		if d.opts.NilMode == NilPreserve {
+			present, err := d.readBool()
+			if err != nil {
+				return err
+			}
+			if !present {
+				v.Set(reflect.Zero(t))
+				d.leave()
+				return nil
+			}
+		}
+		pv := reflect.New(et)
+		if err := elem(d, pv.Elem()); err != nil {
+			return err
+		}
+		v.Set(pv)
+		d.leave()
+		return nil
+	}
+}
+
+func newArrayDecoder(t reflect.Type) decFunc {
+	if isByteType(t.Elem()) {
+		return decByteArray
+	}
+	var packed decFunc
+	if isFixedType(t.Elem()) {
+		packed = newPackedDecoder(t)
+	}
+	return arrayDecoder(decPlanFor(t.Elem()), packed)
+}
+
+// arrayDecoder returns a plan that reads an array element by element using
+// elem or, if packed is not nil and Packed option is set, using packed.
+func arrayDecoder(elem, packed decFunc) decFunc {
+	return func(d *Decoder, v reflect.Value) (err error) {
+		if packed != nil && d.opts.Packed {
+			return packed(d, v)
+		}
+		if err = d.enter(); err != nil {
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err = elem(d, v.Index(i)); err != nil {
+				return d.pathError(err, indexSeg(i))
+			}
+		}
+		d.leave()
+		return
+	}
+}
+
+// decByteArray reads a byte array in a single read.
+func decByteArray(d *Decoder, v reflect.Value) error {
+	return d.readFull(v.Slice(0, v.Len()).Bytes())
+}
+
+// newSliceDecoder returns a plan that reads a slice. The slice is grown as
+// elements are read instead of being allocated from the length prefix.
+func newSliceDecoder(t reflect.Type) decFunc {
+	if isByteType(t.Elem()) {
+		return decByteSlice
+	}
+	var packed decFunc
+	if isFixedType(t.Elem()) {
+		packed = newPackedDecoder(t)
+	}
+	return sliceDecoder(t, decPlanFor(t.Elem()), packed)
+}
+
+// sliceDecoder returns a plan that reads a slice of type t element by
+// element using elem or, if packed is not nil and Packed option is set,
+// using packed.
+func sliceDecoder(t reflect.Type, elem, packed decFunc) decFunc {
+	size := t.Elem().Size()
+	return func(d *Decoder, v reflect.Value) (err error) {
+		if packed != nil && d.opts.Packed {
+			return packed(d, v)
+		}
+		if err = d.enter(); err != nil {
+			return
+		}
+		l, err := d.readNilLen(d.opts.MaxSliceLen)
+		if err != nil {
+			return
+		}
+		if l < 0 {
+			v.Set(reflect.Zero(t))
+			d.leave()
+			return
+		}
+		n := preallocLen(l, size)
+		s := reflect.MakeSlice(t, n, n)
+		ec := d.elemCounter(d.opts.MaxSliceLen)
+		for i := 0; i < l; i++ {
+			s = growSlice(s, i, l)
+			if err = elem(d, s.Index(i)); err == nil {
+				err = ec.next(d)
+			}
+			if err != nil {
+				return d.pathError(err, indexSeg(i))
+			}
+		}
+		v.Set(s)
+		d.leave()
+		return
+	}
+}
+
+// decByteSlice reads a length prefixed byte slice.
+func decByteSlice(d *Decoder, v reflect.Value) error {
+	l, err := d.readNilLen(d.opts.MaxSliceLen)
+	if err != nil {
+		return err
+	}
+	if l < 0 {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	p, err := d.readAlias(l)
+	if err != nil {
+		return err
+	}
+	v.SetBytes(p)
+	return nil
+}
+
+func newMapDecoder(t reflect.Type) decFunc {
+	kt, et := t.Key(), t.Elem()
+	key := decPlanFor(kt)
+	elem := decPlanFor(et)
+	return func(d *Decoder, v reflect.Value) (err error) {
+		if err = d.enter(); err != nil {
+			return
+		}
+		l, err := d.readNilLen(d.opts.MaxMapLen)
+		if err != nil {
+			return
+		}
+		if l < 0 {
+			v.Set(reflect.Zero(t))
+			d.leave()
+			return
+		}
+		v.Set(reflect.MakeMap(t))
+		var kc *keyCheck
+		if d.opts.Canonical {
+			kc = &keyCheck{nm: d.opts.NilMode}
+		}
+		ec := d.elemCounter(d.opts.MaxMapLen)
+		for i := 0; i < l; i++ {
+			kv := reflect.New(kt).Elem()
+			if err = key(d, kv); err != nil {
+				return d.pathError(err, mapKeySeg(i))
+			}
+			if kc != nil {
+				if err = kc.next(kv); err != nil {
+					return d.pathError(err, keySeg(kv))
+				}
+			}
+			ev := reflect.New(et).Elem()
+			if err = elem(d, ev); err == nil {
+				err = ec.next(d)
+			}
+			if err != nil {
+				return d.pathError(err, keySeg(kv))
+			}
+			v.SetMapIndex(kv, ev)
+		}
+		d.leave()
+		return
+	}
+}
+
+// decField is a compiled plan for a struct field.
+type decField struct {
+	index []int
+	name  string
+	bit   int
+	dec   decFunc
+}
+
+// fieldDecoder returns a decoding plan for struct field f which follows
+// encoding options set in its' tag.
+func fieldDecoder(f structField) decFunc {
+	switch {
+	case f.embeddedPtr:
+		return newEmbeddedPtrDecoder(f.typ)
+	case f.tag.packed:
+		return newPackedDecoder(f.typ)
+	case !f.tag.intsSet:
+	case isIntType(f.typ):
+		return newIntDecoder(f.typ, f.tag.ints)
+	case !isByteType(f.typ.Elem()):
+		elem := newIntDecoder(f.typ.Elem(), f.tag.ints)
+		if f.typ.Kind() == reflect.Array {
+			return arrayDecoder(elem, nil)
+		}
+		return sliceDecoder(f.typ, elem, nil)
+	}
+	return decPlanFor(f.typ)
+}
+
+// newStructDecoder returns a plan that reads exported and included fields
+// of a struct in the order defined by structFields. Omitempty fields that
+// were not written are set to their zero value.
+func newStructDecoder(t reflect.Type) decFunc {
+	sf, omit, err := structFields(t)
+	if err != nil {
+		return func(d *Decoder, v reflect.Value) error { return err }
+	}
+	fields := make([]decField, 0, len(sf))
+	for _, f := range sf {
+		fields = append(fields, decField{f.index, f.name, f.bit, fieldDecoder(f)})
+	}
+	return func(d *Decoder, v reflect.Value) (err error) {
+		if err = d.enter(); err != nil {
+			return
+		}
+		var (
+			small [8]byte
+			bm    []byte
+		)
+		if omit > 0 {
+			if n := (omit + 7) / 8; n <= len(small) {
+				bm = small[:n]
+			} else {
+				bm = make([]byte, n)
+			}
+			for i := range bm {
+				if bm[i], err = d.r.ReadByte(); err != nil {
+					return
+				}
+			}
+		}
+		for _, f := range fields {
+			fv := fieldValue(v, f.index)
+			if f.bit >= 0 && bm[f.bit/8]&(1<<uint(f.bit%8)) == 0 {
+				fv.Set(reflect.Zero(fv.Type()))
+				continue
+			}
+			if err = f.dec(d, fv); err != nil {
+				return d.pathError(err, fieldSeg(f.name))
+			}
+		}
+		d.leave()
+		return
+	}
+}
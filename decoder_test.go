@@ -0,0 +1,325 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"runtime"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// hostileLen returns a payload consisting of only a huge length prefix.
+func hostileLen() []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutVarint(buf, 1<<40)]
+}
+
+func TestDecoderHostileLength(t *testing.T) {
+	for _, val := range []interface{}{
+		new([]uint64), new(string), new(map[int]int), new([]TreeNode),
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if err := NewDecoder(bytes.NewReader(hostileLen())).Decode(val); err == nil {
+			t.Fatalf("%T: expected an error", val)
+		}
+		runtime.ReadMemStats(&after)
+		if n := after.TotalAlloc - before.TotalAlloc; n > 4*maxPrealloc {
+			t.Fatalf("%T: allocated %d bytes for a hostile length", val, n)
+		}
+	}
+}
+
+// Skipped has no fields that are written.
+type Skipped struct {
+	A int `binaryex:"-"`
+	b int
+}
+
+func TestDecoderHostileEmptyLength(t *testing.T) {
+	for _, val := range []interface{}{
+		new([]struct{}), new(map[struct{}]struct{}), new([][0]int), new([]Skipped),
+	} {
+		if err := NewDecoder(bytes.NewReader(hostileLen())).Decode(val); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("%T: expected ErrLimitExceeded, got %v", val, err)
+		}
+		typ := reflect.TypeOf(val).Elem()
+		if err := NewDecoder(bytes.NewReader(hostileLen())).Skip(typ); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("%T: Skip: expected ErrLimitExceeded, got %v", val, err)
+		}
+	}
+	// A length within the limit.
+	data, err := Marshal(make([]struct{}, 1000))
+	if err != nil {
+		t.Fatal("Marshal failed", err)
+	}
+	var in []struct{}
+	if err := Unmarshal(data, &in); err != nil || len(in) != 1000 {
+		t.Fatalf("Unmarshal failed: %d, %v", len(in), err)
+	}
+
+	// A slice length and an array length in a type descriptor of a self
+	// describing stream.
+	sd := func(val interface{}) []byte {
+		buf := bytes.NewBuffer(nil)
+		if err := NewEncoderWithOptions(buf, EncoderOptions{SelfDescribing: true}).Encode(val); err != nil {
+			t.Fatal("Encode failed", err)
+		}
+		return buf.Bytes()
+	}
+	slice := sd([]struct{}{{}})
+	slice = append(slice[:len(slice)-1], hostileLen()...)
+	one, two := sd([1]struct{}{}), sd([2]struct{}{})
+	i := 0
+	for one[i] == two[i] {
+		i++
+	}
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	lenBuf = lenBuf[:binary.PutUvarint(lenBuf, 1<<40)]
+	array := append(append(append([]byte{}, one[:i]...), lenBuf...), one[i+1:]...)
+	for _, data := range [][]byte{slice, array} {
+		for _, in := range []interface{}{new([]struct{}), new([3]struct{})} {
+			dec := NewDecoderWithOptions(bytes.NewReader(data), DecoderOptions{SelfDescribing: true})
+			if err := dec.Decode(in); !errors.Is(err, ErrLimitExceeded) {
+				t.Fatalf("%T: expected ErrLimitExceeded, got %v", in, err)
+			}
+		}
+		dec := NewDecoderWithOptions(bytes.NewReader(data), DecoderOptions{SelfDescribing: true})
+		if err := dec.Skip(nil); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("Skip: expected ErrLimitExceeded, got %v", err)
+		}
+	}
+}
+
+func TestDecoderHostileSelfReference(t *testing.T) {
+	// A pointer whose element is the pointer itself.
+	ptr := []byte{'B', 'X', 1, 0, wtFirstID, wtPtr, wtFirstID}
+	dec := NewDecoderWithOptions(bytes.NewReader(ptr), DecoderOptions{SelfDescribing: true})
+	if err := dec.Skip(nil); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("Skip: expected ErrLimitExceeded, got %v", err)
+	}
+	// A struct with an unknown field of such a pointer type.
+	field := []byte{'B', 'X', 1, 0, wtFirstID, wtStruct, 1, 0, 2, 'Z', 0, wtFirstID + 1, wtPtr, wtFirstID + 1}
+	var in struct{ A int }
+	dec = NewDecoderWithOptions(bytes.NewReader(field), DecoderOptions{SelfDescribing: true})
+	if err := dec.Decode(&in); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("Decode: expected ErrLimitExceeded, got %v", err)
+	}
+	// A deep recursive type within the limit.
+	tree := TreeNode{0, []TreeNode{}}
+	for i := 1; i < 1000; i++ {
+		tree = TreeNode{i, []TreeNode{tree}}
+	}
+	checkRoundTrip(t, EncoderOptions{SelfDescribing: true}, DecoderOptions{SelfDescribing: true}, tree)
+}
+
+func TestDecoderLimits(t *testing.T) {
+	tree := TreeNode{1, []TreeNode{{2, []TreeNode{{3, nil}}}}}
+	for _, test := range []struct {
+		opts DecoderOptions
+		out  interface{}
+		in   interface{}
+	}{
+		{DecoderOptions{MaxSliceLen: 2}, []int{1, 2, 3}, new([]int)},
+		{DecoderOptions{MaxSliceLen: 2}, []byte("abc"), new([]byte)},
+		{DecoderOptions{MaxMapLen: 1}, map[int]int{1: 1, 2: 2}, new(map[int]int)},
+		{DecoderOptions{MaxStringLen: 3}, "abcd", new(string)},
+		{DecoderOptions{MaxTotalBytes: 8}, []int{1, 2, 3, 4, 5, 6, 7, 8}, new([]int)},
+		{DecoderOptions{MaxDepth: 4}, tree, new(TreeNode)},
+	} {
+		buf := bytes.NewBuffer(nil)
+		if err := Write(buf, test.out); err != nil {
+			t.Fatal("Write failed", err)
+		}
+		data := buf.Bytes()
+		dec := NewDecoderWithOptions(bytes.NewReader(data), test.opts)
+		if err := dec.Decode(test.in); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("%+v: expected ErrLimitExceeded, got %v", test.opts, err)
+		}
+		dec = NewSliceDecoder(data, test.opts)
+		if err := dec.Decode(test.in); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("%+v: slice: expected ErrLimitExceeded, got %v", test.opts, err)
+		}
+		// Same value decodes in self describing mode or without limits.
+		if err := NewDecoder(bytes.NewReader(data)).Decode(test.in); err != nil {
+			t.Fatal("Decode failed", err)
+		}
+		buf.Reset()
+		enc := NewEncoderWithOptions(buf, EncoderOptions{SelfDescribing: true})
+		if err := enc.Encode(test.out); err != nil {
+			t.Fatal("Encode failed", err)
+		}
+		test.opts.SelfDescribing = true
+		if test.opts.MaxTotalBytes > 0 {
+			test.opts.MaxTotalBytes += int64(buf.Len() - len(data))
+		}
+		dec = NewDecoderWithOptions(buf, test.opts)
+		if err := dec.Decode(test.in); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("%+v: expected ErrLimitExceeded, got %v", test.opts, err)
+		}
+	}
+}
+
+func TestDecoderMaxTotalBytesPerValue(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	for i := 0; i < 10; i++ {
+		if err := Write(buf, "value"); err != nil {
+			t.Fatal("Write failed", err)
+		}
+	}
+	dec := NewDecoderWithOptions(buf, DecoderOptions{MaxTotalBytes: 6})
+	for i := 0; i < 10; i++ {
+		var s string
+		if err := dec.Decode(&s); err != nil {
+			t.Fatal("Decode failed", err)
+		}
+	}
+}
+
+// stallReader returns 0, nil from every other Read.
+type stallReader struct {
+	r     io.Reader
+	stall bool
+}
+
+func (sr *stallReader) Read(p []byte) (int, error) {
+	if sr.stall = !sr.stall; sr.stall {
+		return 0, nil
+	}
+	return sr.r.Read(p)
+}
+
+var shortReaders = map[string]func(io.Reader) io.Reader{
+	"OneByteReader": iotest.OneByteReader,
+	"HalfReader":    iotest.HalfReader,
+	"DataErrReader": iotest.DataErrReader,
+	"stallReader":   func(r io.Reader) io.Reader { return &stallReader{r: r} },
+}
+
+// shortReadValue returns a value exercising all read paths.
+func shortReadValue() interface{} {
+	out := struct {
+		BaseTypes
+		PointerTypes
+		InterfaceTypes
+		Time time.Time
+	}{}
+	out.BaseTypes.init()
+	out.PointerTypes.init()
+	out.InterfaceTypes.Shape = Rect{1, 2}
+	out.InterfaceTypes.Shapes = []Shape{}
+	out.Time = time.Date(2019, 1, 2, 3, 4, 5, 6, time.UTC)
+	return out
+}
+
+func TestDecoderShortReads(t *testing.T) {
+	out := shortReadValue()
+	buf := bytes.NewBuffer(nil)
+	if err := Write(buf, out); err != nil {
+		t.Fatal("Write failed", err)
+	}
+	data := buf.Bytes()
+	buf = bytes.NewBuffer(nil)
+	enc := NewEncoderWithOptions(buf, EncoderOptions{SelfDescribing: true})
+	if err := enc.Encode(out); err != nil {
+		t.Fatal("Encode failed", err)
+	}
+	sdata := buf.Bytes()
+	for name, wrap := range shortReaders {
+		in := reflect.New(reflect.TypeOf(out))
+		if err := Read(wrap(bytes.NewReader(data)), in.Interface()); err != nil {
+			t.Fatalf("%s: Read failed: %v", name, err)
+		}
+		if !reflect.DeepEqual(in.Elem().Interface(), out) {
+			t.Fatalf("%s: Read/Write missmatch", name)
+		}
+		in = reflect.New(reflect.TypeOf(out))
+		if err := NewDecoder(wrap(bytes.NewReader(data))).Decode(in.Interface()); err != nil {
+			t.Fatalf("%s: Decode failed: %v", name, err)
+		}
+		if !reflect.DeepEqual(in.Elem().Interface(), out) {
+			t.Fatalf("%s: Encode/Decode missmatch", name)
+		}
+		in = reflect.New(reflect.TypeOf(out))
+		dec := NewDecoderWithOptions(wrap(bytes.NewReader(sdata)), DecoderOptions{SelfDescribing: true})
+		if err := dec.Decode(in.Interface()); err != nil {
+			t.Fatalf("%s: Decode self describing failed: %v", name, err)
+		}
+		if !reflect.DeepEqual(in.Elem().Interface(), out) {
+			t.Fatalf("%s: Encode/Decode self describing missmatch", name)
+		}
+		var s string
+		if err := ReadString(wrap(bytes.NewReader([]byte{8, 't', 'e', 's', 't'})), &s); err != nil || s != "test" {
+			t.Fatalf("%s: ReadString failed: %q, %v", name, s, err)
+		}
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	out := shortReadValue()
+	buf := bytes.NewBuffer(nil)
+	if err := Write(buf, out); err != nil {
+		t.Fatal("Write failed", err)
+	}
+	data := buf.Bytes()
+	for i := 0; i < len(data); i++ {
+		in := reflect.New(reflect.TypeOf(out))
+		err := Read(iotest.OneByteReader(bytes.NewReader(data[:i])), in.Interface())
+		if i == 0 {
+			if err != io.EOF {
+				t.Fatalf("expected io.EOF at offset 0, got %v", err)
+			}
+			continue
+		}
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("expected io.ErrUnexpectedEOF at offset %d, got %v", i, err)
+		}
+		if err := Unmarshal(data[:i], in.Interface()); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("Unmarshal: expected io.ErrUnexpectedEOF at offset %d, got %v", i, err)
+		}
+	}
+	for _, val := range []interface{}{new(bool), new(int), new(float64), new(complex64)} {
+		if err := Read(bytes.NewReader(nil), val); err != io.EOF {
+			t.Fatalf("%T: expected io.EOF, got %v", val, err)
+		}
+	}
+}
+
+func TestSliceDecoderAlias(t *testing.T) {
+	out := ByteTypes{Bytes: []byte("alias"), Named: NamedBytes{1, 2}, Nil: []byte{}}
+	data, err := Marshal(out)
+	if err != nil {
+		t.Fatal("Marshal failed", err)
+	}
+	for _, alias := range []bool{false, true} {
+		p := append([]byte(nil), data...)
+		var in ByteTypes
+		dec := NewSliceDecoder(p, DecoderOptions{AliasBytes: alias})
+		if err := dec.Decode(&in); err != nil {
+			t.Fatal("Decode failed", err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("Encode/Decode missmatch: %v, %v", in, out)
+		}
+		for i := range p {
+			p[i] = 0
+		}
+		if shared := in.Bytes[0] == 0; shared != alias {
+			t.Fatalf("AliasBytes %t: slice shared with input: %t", alias, shared)
+		}
+		if alias && cap(in.Bytes) != len(in.Bytes) {
+			t.Fatal("aliased slice can be appended to over input")
+		}
+		if err := dec.Decode(&in); err != io.EOF {
+			t.Fatalf("expected io.EOF, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import "reflect"
+
+// presenceMarked returns true if an embedded pointer is prefixed by a
+// presence marker of its' own, as pointers are not in NilLegacy mode
+// without References.
+func presenceMarked(nm NilMode, refs bool) bool {
+	return nm != NilPreserve && !refs
+}
+
+// newEmbeddedPtrEncoder returns a plan that writes an embedded pointer of
+// type t as newPtrEncoder does in NilPreserve mode, so that a nil embedded
+// pointer is read back as nil in any NilMode.
+func newEmbeddedPtrEncoder(t reflect.Type) encFunc {
+	ptr := encPlanFor(t)
+	return func(e *Encoder, v reflect.Value) error {
+		if presenceMarked(e.opts.NilMode, e.opts.References) {
+			if err := e.writeBool(!v.IsNil()); err != nil || v.IsNil() {
+				return err
+			}
+		}
+		return ptr(e, v)
+	}
+}
+
+// newEmbeddedPtrDecoder returns a plan that reads an embedded pointer of
+// type t written by newEmbeddedPtrEncoder.
+func newEmbeddedPtrDecoder(t reflect.Type) decFunc {
+	ptr := decPlanFor(t)
+	return func(d *Decoder, v reflect.Value) error {
+		present, err := d.readPresence(true)
+		if err != nil {
+			return err
+		}
+		if !present {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		return ptr(d, v)
+	}
+}
+
+// newEmbeddedPtrSkipper returns a plan that skips an embedded pointer of
+// type t written by newEmbeddedPtrEncoder.
+func newEmbeddedPtrSkipper(t reflect.Type) skipFunc {
+	ptr := skipPlanFor(t)
+	return func(d *Decoder) error {
+		present, err := d.readPresence(true)
+		if err != nil || !present {
+			return err
+		}
+		return ptr(d)
+	}
+}
+
+// readPresence reads the presence marker of an embedded pointer if marked
+// is true and the marker was written and returns true if the pointer is not
+// nil or if there is no marker.
+func (d *Decoder) readPresence(marked bool) (bool, error) {
+	if !marked || !presenceMarked(d.opts.NilMode, d.opts.References) {
+		return true, nil
+	}
+	return d.readBool()
+}
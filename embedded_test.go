@@ -0,0 +1,230 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Stamped embeds a type with promoted marshaler methods.
+type Stamped struct {
+	time.Time
+	Name string
+}
+
+type EmbeddedMarshalers struct {
+	Stamped
+	MultiMarshaler
+	Value int
+}
+
+// Stamp embeds a type with marshaler methods and declares its' own.
+type Stamp struct {
+	time.Time
+	Zone string
+}
+
+func (s Stamp) MarshalBinary() ([]byte, error) {
+	return []byte(s.Zone), nil
+}
+
+func (s *Stamp) UnmarshalBinary(p []byte) error {
+	s.Zone = string(p)
+	return nil
+}
+
+// StampedOrder embeds a Marshaler and declares its' own Marshaler methods.
+type StampedOrder struct {
+	Stamp
+	ID int
+}
+
+func (x *StampedOrder) MarshalBinaryEx(e *Encoder) (err error) {
+	if err = e.WriteValue(&x.Stamp); err != nil {
+		return
+	}
+	return e.WriteInt(int64(x.ID), 8)
+}
+
+func (x *StampedOrder) UnmarshalBinaryEx(d *Decoder) (err error) {
+	if err = d.ReadValue(&x.Stamp); err != nil {
+		return
+	}
+	id, err := d.ReadInt(8)
+	x.ID = int(id)
+	return
+}
+
+type EmbeddedPointer struct {
+	*OrderItem
+	ID int
+}
+
+type FlatBase struct {
+	ID   int    `binaryex:"1"`
+	Name string `binaryex:",omitempty"`
+}
+
+type Flattened struct {
+	Kind     string
+	FlatBase `binaryex:"flatten"`
+	Count    int `binaryex:",omitempty"`
+}
+
+// Declared has fields of Flattened declared in place of FlatBase.
+type Declared struct {
+	Kind  string
+	ID    int    `binaryex:"1"`
+	Name  string `binaryex:",omitempty"`
+	Count int    `binaryex:",omitempty"`
+}
+
+func TestEmbeddedMarshalers(t *testing.T) {
+	out := EmbeddedMarshalers{
+		Stamped:        Stamped{time.Unix(1e9, 0).UTC(), "name"},
+		MultiMarshaler: MultiMarshaler{7},
+		Value:          3,
+	}
+	for _, sd := range []bool{false, true} {
+		checkRoundTrip(t, EncoderOptions{SelfDescribing: sd}, DecoderOptions{SelfDescribing: sd}, out)
+	}
+	if marshalers(reflect.TypeOf(Stamped{}), true) != 0 {
+		t.Fatal("promoted marshaler used")
+	}
+}
+
+func TestEmbeddedDeclaredMarshalers(t *testing.T) {
+	for _, k := range []MarshalerKind{BinaryExMarshaler, BinaryMarshaler} {
+		typ := reflect.TypeOf(StampedOrder{})
+		if k == BinaryMarshaler {
+			typ = reflect.TypeOf(Stamp{})
+		}
+		if !marshalers(typ, true).has(k) || !marshalers(typ, false).has(k) {
+			t.Fatalf("%v: declared marshaler of %v not used", typ, k)
+		}
+	}
+	out := StampedOrder{Stamp{time.Unix(1e9, 0).UTC(), "CET"}, 7}
+	data, err := Marshal(out)
+	if err != nil {
+		t.Fatal("Marshal failed", err)
+	}
+	// Zone only, as a byte slice, followed by ID.
+	if !bytes.Equal(data, []byte{6, 'C', 'E', 'T', 14}) {
+		t.Fatalf("unexpected encoding % x", data)
+	}
+	var in StampedOrder
+	if err := Unmarshal(data, &in); err != nil {
+		t.Fatal("Unmarshal failed", err)
+	}
+	if in.Zone != out.Zone || in.ID != out.ID || !in.Time.IsZero() {
+		t.Fatalf("Marshal/Unmarshal missmatch: %v", in)
+	}
+}
+
+func TestEmbeddedNilPointer(t *testing.T) {
+	for _, opts := range []struct {
+		e EncoderOptions
+		d DecoderOptions
+	}{
+		{},
+		{EncoderOptions{NilMode: NilPreserve}, DecoderOptions{NilMode: NilPreserve}},
+		{EncoderOptions{References: true}, DecoderOptions{References: true}},
+		{EncoderOptions{SelfDescribing: true}, DecoderOptions{SelfDescribing: true}},
+	} {
+		for _, out := range []EmbeddedPointer{
+			{nil, 1},
+			{&OrderItem{"item", 1.5}, 2},
+		} {
+			buf := bytes.NewBuffer(nil)
+			enc := NewEncoderWithOptions(buf, opts.e)
+			for i := 0; i < 2; i++ {
+				if err := enc.Encode(out); err != nil {
+					t.Fatal("Encode failed", err)
+				}
+			}
+			dec := NewDecoderWithOptions(buf, opts.d)
+			if err := dec.Skip(reflect.TypeOf(out)); err != nil {
+				t.Fatal("Skip failed", err)
+			}
+			in := EmbeddedPointer{OrderItem: &OrderItem{}}
+			if err := dec.Decode(&in); err != nil {
+				t.Fatal("Decode failed", err)
+			}
+			if !reflect.DeepEqual(in, out) {
+				t.Fatalf("%+v: Encode/Decode missmatch: in\n%v, out:\n%v\n", opts, in, out)
+			}
+		}
+	}
+}
+
+func TestEmbeddedFlatten(t *testing.T) {
+	out := Flattened{"kind", FlatBase{1, "name"}, 0}
+	flat, err := Marshal(out)
+	if err != nil {
+		t.Fatal("Marshal failed", err)
+	}
+	declared, err := Marshal(Declared{"kind", 1, "name", 0})
+	if err != nil {
+		t.Fatal("Marshal failed", err)
+	}
+	if !bytes.Equal(flat, declared) {
+		t.Fatalf("flattened and declared differ:\n% x\n% x", flat, declared)
+	}
+	var in Flattened
+	if err := Unmarshal(flat, &in); err != nil {
+		t.Fatal("Unmarshal failed", err)
+	}
+	if in != out {
+		t.Fatalf("Marshal/Unmarshal missmatch: %v", in)
+	}
+
+	// Fields move out of the embedded struct in a self describing stream.
+	buf := bytes.NewBuffer(nil)
+	if err := NewEncoderWithOptions(buf, EncoderOptions{SelfDescribing: true}).Encode(out); err != nil {
+		t.Fatal("Encode failed", err)
+	}
+	var d Declared
+	if err := NewDecoderWithOptions(buf, DecoderOptions{SelfDescribing: true}).Decode(&d); err != nil {
+		t.Fatal("Decode failed", err)
+	}
+	if d != (Declared{"kind", 1, "name", 0}) {
+		t.Fatalf("Encode/Decode missmatch: %v", d)
+	}
+}
+
+func TestEmbeddedInvalidTag(t *testing.T) {
+	for _, val := range []interface{}{
+		struct {
+			*FlatBase `binaryex:"flatten"`
+		}{},
+		struct {
+			Base FlatBase `binaryex:"flatten"`
+		}{},
+		struct {
+			FlatBase `binaryex:"2,flatten"`
+		}{},
+		struct {
+			FlatBase `binaryex:"flatten,nest"`
+		}{},
+		struct {
+			FlatBase `binaryex:"flatten"`
+			Name     string
+		}{},
+	} {
+		if _, err := Marshal(val); !errors.Is(err, ErrInvalidTag) {
+			t.Fatalf("%T: expected ErrInvalidTag, got %v", val, err)
+		}
+	}
+	if _, err := Marshal(struct {
+		FlatBase `binaryex:"nest"`
+		Name     string
+	}{}); err != nil {
+		t.Fatal("Marshal failed", err)
+	}
+}
@@ -0,0 +1,610 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"sync"
+)
+
+// Encoder writes values to an output stream.
+//
+// Encoder compiles an encoding plan for each type it encounters once and
+// caches it for the lifetime of the program so encoding a stream of values
+// of the same type does not repeat the reflection work on each value.
+//
+// If the writer given to NewEncoder does not implement io.ByteWriter it is
+// wrapped in a bufio.Writer. In that case Flush must be called after the
+// last Encode to write any buffered data to the underlying writer.
+type Encoder struct {
+	w     io.Writer
+	bw    *bufio.Writer
+	buf   [16]byte
+	opts  EncoderOptions
+	types map[reflect.Type]uint64
+	// n is the number of bytes written.
+	n int64
+	// refs holds ids of pointers written in References mode.
+	refs map[refKey]uint64
+	// level and visiting track pointers, slices and maps being written.
+	level    int
+	visiting map[refKey]struct{}
+	// scratch is a buffer for packed arrays and slices.
+	scratch []byte
+	// codecs holds codecs registered with the Encoder.
+	codecs map[reflect.Type]EncodeFunc
+
+	// self describing stream state.
+	started bool
+	defs    map[wireDef]uint64
+}
+
+// NewEncoder returns a new Encoder that writes to w using
+// DefaultEncoderOptions.
+func NewEncoder(w io.Writer) *Encoder {
+	return NewEncoderWithOptions(w, DefaultEncoderOptions)
+}
+
+// NewEncoderWithOptions returns a new Encoder that writes to w using opts.
+func NewEncoderWithOptions(w io.Writer, opts EncoderOptions) *Encoder {
+	e := &Encoder{opts: opts}
+	if _, ok := w.(io.ByteWriter); ok || w == nil {
+		e.w = w
+	} else {
+		e.bw = bufio.NewWriter(w)
+		e.w = e.bw
+	}
+	return e
+}
+
+// Encode writes val to the stream or returns an error if one occured.
+// See Write for details on how values are encoded.
+func (e *Encoder) Encode(val interface{}) error {
+	return e.EncodeValue(reflect.Indirect(reflect.ValueOf(val)))
+}
+
+// EncodeValue writes a reflect value v to the stream or returns an error if
+// one occured.
+//
+// Errors are returned as an *EncodeError describing where writing failed.
+func (e *Encoder) EncodeValue(v reflect.Value) error {
+	return e.encodeError(e.encodeValue(v, nil), valueType(v))
+}
+
+// encodeValue writes a top level reflect value v to the stream using plan
+// f compiled for its' type or a cached plan if f is nil. Panics are
+// recovered and returned as errors.
+func (e *Encoder) encodeValue(v reflect.Value, f encFunc) (err error) {
+	defer recoverError(&err)
+	if e.w == nil {
+		return ErrInvalidArgument
+	}
+	if f == nil && v.IsValid() {
+		f = encPlanFor(v.Type())
+	}
+	e.resetRefs()
+	if e.opts.SelfDescribing {
+		if err := e.writeHeader(); err != nil {
+			return err
+		}
+		if !v.IsValid() {
+			return e.writeUvarint(wtNil)
+		}
+		if err := e.writeWireType(v.Type(), fieldTag{}); err != nil {
+			return err
+		}
+		return f(e, v)
+	}
+	// Write 0 for nil values.
+	if !v.IsValid() {
+		return e.writeVarint(0)
+	}
+	return f(e, v)
+}
+
+// encodeRaw writes a top level reflect value v using plan f without self
+// description. Panics are recovered and returned as errors.
+func (e *Encoder) encodeRaw(v reflect.Value, f encFunc) (err error) {
+	defer recoverError(&err)
+	if e.w == nil {
+		return ErrInvalidArgument
+	}
+	return f(e, v)
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (e *Encoder) Flush() error {
+	if e.bw == nil {
+		return nil
+	}
+	return e.bw.Flush()
+}
+
+// encoderPool holds unbuffered Encoders used by package level functions.
+var encoderPool = sync.Pool{
+	New: func() interface{} { return &Encoder{} },
+}
+
+// getEncoder returns an unbuffered Encoder writing directly to w.
+func getEncoder(w io.Writer) *Encoder {
+	e := encoderPool.Get().(*Encoder)
+	e.w = w
+	e.n = 0
+	e.opts = DefaultEncoderOptions
+	return e
+}
+
+// putEncoder returns e to the pool.
+func putEncoder(e *Encoder) {
+	e.w = nil
+	for t := range e.types {
+		delete(e.types, t)
+	}
+	e.resetRefs()
+	e.started = false
+	for t := range e.defs {
+		delete(e.defs, t)
+	}
+	encoderPool.Put(e)
+}
+
+// write writes p to the underlying writer.
+func (e *Encoder) write(p []byte) (err error) {
+	n, err := e.w.Write(p)
+	e.n += int64(n)
+	return
+}
+
+// writeVarint writes x as a VarInt.
+func (e *Encoder) writeVarint(x int64) error {
+	n := binary.PutVarint(e.buf[:], x)
+	return e.write(e.buf[:n])
+}
+
+// writeUvarint writes x as an UVarInt.
+func (e *Encoder) writeUvarint(x uint64) error {
+	n := binary.PutUvarint(e.buf[:], x)
+	return e.write(e.buf[:n])
+}
+
+// writeLen writes a string, slice or map length prefix.
+func (e *Encoder) writeLen(l int) error {
+	return e.writeVarint(int64(l))
+}
+
+// writeBool writes b as a single byte.
+func (e *Encoder) writeBool(b bool) error {
+	e.buf[0] = 0
+	if b {
+		e.buf[0] = 1
+	}
+	return e.write(e.buf[:1])
+}
+
+// writeFloat writes f as a LittleEndian float64.
+func (e *Encoder) writeFloat(f float64) error {
+	binary.LittleEndian.PutUint64(e.buf[:], math.Float64bits(f))
+	return e.write(e.buf[:8])
+}
+
+// writeFloat32 writes f as a LittleEndian float32.
+func (e *Encoder) writeFloat32(f float32) error {
+	binary.LittleEndian.PutUint32(e.buf[:], math.Float32bits(f))
+	return e.write(e.buf[:4])
+}
+
+// writeComplex64 writes c as a LittleEndian complex64.
+func (e *Encoder) writeComplex64(c complex64) error {
+	binary.LittleEndian.PutUint32(e.buf[:], math.Float32bits(real(c)))
+	binary.LittleEndian.PutUint32(e.buf[4:], math.Float32bits(imag(c)))
+	return e.write(e.buf[:8])
+}
+
+// writeComplex writes c as a LittleEndian complex128.
+func (e *Encoder) writeComplex(c complex128) error {
+	binary.LittleEndian.PutUint64(e.buf[:], math.Float64bits(real(c)))
+	binary.LittleEndian.PutUint64(e.buf[8:], math.Float64bits(imag(c)))
+	return e.write(e.buf[:16])
+}
+
+// writeString writes a length prefixed string s.
+func (e *Encoder) writeString(s string) (err error) {
+	if err = e.writeLen(len(s)); err != nil {
+		return
+	}
+	n, err := io.WriteString(e.w, s)
+	e.n += int64(n)
+	return
+}
+
+// writeBytes writes a length prefixed byte slice p the same way a []byte
+// value is written.
+func (e *Encoder) writeBytes(p []byte) (err error) {
+	if err = e.writeLen(len(p)); err != nil {
+		return
+	}
+	return e.write(p)
+}
+
+// encFunc is a compiled encoding plan for a type.
+type encFunc func(e *Encoder, v reflect.Value) error
+
+// encPlans caches compiled encoding plans, map[reflect.Type]encFunc.
+var encPlans sync.Map
+
+// encPlanFor returns a cached encoding plan for type t, compiling it first if
+// required.
+func encPlanFor(t reflect.Type) encFunc {
+	if fi, ok := encPlans.Load(t); ok {
+		return fi.(encFunc)
+	}
+	// Store an indirect plan first so recursive types resolve to it while
+	// the real plan is being compiled.
+	var (
+		wg sync.WaitGroup
+		f  encFunc
+	)
+	wg.Add(1)
+	fi, loaded := encPlans.LoadOrStore(t, encFunc(func(e *Encoder, v reflect.Value) error {
+		wg.Wait()
+		return f(e, v)
+	}))
+	if loaded {
+		return fi.(encFunc)
+	}
+	f = newEncFunc(t)
+	wg.Done()
+	encPlans.Store(t, f)
+	return f
+}
+
+// newEncFunc compiles an encoding plan for type t which uses a codec
+// registered for t or marshaler methods of t if it has any.
+func newEncFunc(t reflect.Type) encFunc {
+	if c, ok := registeredCodec(t); ok {
+		return withCodecs(t, func(e *Encoder, v reflect.Value) error {
+			return e.encodeCodec(c.enc, v)
+		})
+	}
+	if ms := marshalers(t, true); ms != 0 {
+		return withCodecs(t, newMarshalerEncoder(t, ms, newReflectEncFunc(t)))
+	}
+	return withCodecs(t, newReflectEncFunc(t))
+}
+
+// newReflectEncFunc compiles an encoding plan for type t that reflects on
+// its' values.
+func newReflectEncFunc(t reflect.Type) encFunc {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return newPtrEncoder(t)
+	case reflect.Interface:
+		return encInterface
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return encBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return encInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		return encUint
+	case reflect.Float32, reflect.Float64:
+		return encFloat
+	case reflect.Complex64, reflect.Complex128:
+		return encComplex
+	case reflect.String:
+		return encString
+	case reflect.Array:
+		return newArrayEncoder(t)
+	case reflect.Slice:
+		return newSliceEncoder(t)
+	case reflect.Map:
+		return newMapEncoder(t)
+	case reflect.Struct:
+		return newStructEncoder(t)
+	}
+	return encUnsupported
+}
+
+func encUnsupported(e *Encoder, v reflect.Value) error {
+	return ErrUnsupportedValue
+}
+
+func encBool(e *Encoder, v reflect.Value) error {
+	return e.writeBool(v.Bool())
+}
+
+func encInt(e *Encoder, v reflect.Value) error {
+	return e.writeInt(v.Int(), v.Kind(), e.opts.IntEncoding)
+}
+
+func encUint(e *Encoder, v reflect.Value) error {
+	return e.writeUint(v.Uint(), v.Kind(), e.opts.IntEncoding)
+}
+
+// encFloat writes a float at the width of its' kind or as a float64 if
+// WideFloats option is set.
+func encFloat(e *Encoder, v reflect.Value) error {
+	if v.Kind() == reflect.Float32 && !e.opts.WideFloats {
+		return e.writeFloat32(float32(v.Float()))
+	}
+	return e.writeFloat(v.Float())
+}
+
+// encComplex writes a complex number at the width of its' kind or as a
+// complex128 if WideFloats option is set.
+func encComplex(e *Encoder, v reflect.Value) error {
+	if v.Kind() == reflect.Complex64 && !e.opts.WideFloats {
+		return e.writeComplex64(complex64(v.Complex()))
+	}
+	return e.writeComplex(v.Complex())
+}
+
+func encString(e *Encoder, v reflect.Value) error {
+	return e.writeString(v.String())
+}
+
+// newPtrEncoder returns a plan that dereferences a pointer and writes the
+// value it points to. If the pointer is nil a 0 is written in NilLegacy mode
+// and a presence marker is written before the value in NilPreserve mode. If
+// References option is set the pointer is written by writeRef instead.
+func newPtrEncoder(t reflect.Type) encFunc {
+	elem := encPlanFor(t.Elem())
+	return func(e *Encoder, v reflect.Value) (err error) {
+		if e.opts.References {
+			return e.writeRef(v, elem)
+		}
+		if e.opts.NilMode == NilPreserve {
+			if err = e.writeBool(!v.IsNil()); err != nil || v.IsNil() {
+				return
+			}
+		} else if v.IsNil() {
+			return e.writeVarint(0)
+		}
+		if err = e.enterRef(v); err != nil {
+			return
+		}
+		err = elem(e, v.Elem())
+		e.leaveRef(v)
+		return
+	}
+}
+
+// writeNilLen writes a length of -1 and returns true if v is a nil slice
+// or map and e is in NilPreserve mode.
+func (e *Encoder) writeNilLen(v reflect.Value) (bool, error) {
+	if e.opts.NilMode != NilPreserve || !v.IsNil() {
+		return false, nil
+	}
+	return true, e.writeLen(-1)
+}
+
+// isByteType returns true if t is an uint8 type that is written as a single
+// byte when it is an element of an array or a slice.
+func isByteType(t reflect.Type) bool {
+	return t.Kind() == reflect.Uint8 && !hasMarshaler(t)
+}
+
+func newArrayEncoder(t reflect.Type) encFunc {
+	if isByteType(t.Elem()) {
+		return encByteArray
+	}
+	var packed encFunc
+	if isFixedType(t.Elem()) {
+		packed = newPackedEncoder(t)
+	}
+	return arrayEncoder(encPlanFor(t.Elem()), packed)
+}
+
+// arrayEncoder returns a plan that writes an array element by element using
+// elem or, if packed is not nil and Packed option is set, using packed.
+func arrayEncoder(elem, packed encFunc) encFunc {
+	return func(e *Encoder, v reflect.Value) (err error) {
+		if packed != nil && e.opts.Packed {
+			return packed(e, v)
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err = elem(e, v.Index(i)); err != nil {
+				return e.pathError(err, indexSeg(i))
+			}
+		}
+		return
+	}
+}
+
+// encByteArray writes a byte array in a single write.
+func encByteArray(e *Encoder, v reflect.Value) error {
+	if !v.CanAddr() {
+		pv := reflect.New(v.Type())
+		pv.Elem().Set(v)
+		v = pv.Elem()
+	}
+	return e.write(v.Slice(0, v.Len()).Bytes())
+}
+
+func newSliceEncoder(t reflect.Type) encFunc {
+	if isByteType(t.Elem()) {
+		return encByteSlice
+	}
+	var packed encFunc
+	if isFixedType(t.Elem()) {
+		packed = newPackedEncoder(t)
+	}
+	return sliceEncoder(encPlanFor(t.Elem()), packed)
+}
+
+// sliceEncoder returns a plan that writes a slice element by element using
+// elem or, if packed is not nil and Packed option is set, using packed.
+func sliceEncoder(elem, packed encFunc) encFunc {
+	return func(e *Encoder, v reflect.Value) (err error) {
+		if packed != nil && e.opts.Packed {
+			return packed(e, v)
+		}
+		var isNil bool
+		if isNil, err = e.writeNilLen(v); isNil || err != nil {
+			return
+		}
+		if err = e.writeLen(v.Len()); err != nil {
+			return
+		}
+		if err = e.enterRef(v); err != nil {
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err = elem(e, v.Index(i)); err != nil {
+				err = e.pathError(err, indexSeg(i))
+				break
+			}
+		}
+		e.leaveRef(v)
+		return
+	}
+}
+
+// encByteSlice writes a length prefixed byte slice in a single write.
+func encByteSlice(e *Encoder, v reflect.Value) (err error) {
+	var isNil bool
+	if isNil, err = e.writeNilLen(v); isNil || err != nil {
+		return
+	}
+	return e.writeBytes(v.Bytes())
+}
+
+func newMapEncoder(t reflect.Type) encFunc {
+	key := encPlanFor(t.Key())
+	elem := encPlanFor(t.Elem())
+	return func(e *Encoder, v reflect.Value) (err error) {
+		var isNil bool
+		if isNil, err = e.writeNilLen(v); isNil || err != nil {
+			return
+		}
+		if err = e.writeLen(v.Len()); err != nil {
+			return
+		}
+		if err = e.enterRef(v); err != nil {
+			return
+		}
+		if e.opts.Canonical {
+			var keys []reflect.Value
+			if keys, err = e.sortedKeys(v); err != nil {
+				return
+			}
+			for _, k := range keys {
+				if err = e.writeEntry(key, elem, k, v.MapIndex(k)); err != nil {
+					break
+				}
+			}
+		} else {
+			for iter := v.MapRange(); iter.Next(); {
+				if err = e.writeEntry(key, elem, iter.Key(), iter.Value()); err != nil {
+					break
+				}
+			}
+		}
+		e.leaveRef(v)
+		return
+	}
+}
+
+// writeEntry writes map entry k, ev using key and elem plans.
+func (e *Encoder) writeEntry(key, elem encFunc, k, ev reflect.Value) error {
+	if err := key(e, k); err != nil {
+		return e.pathError(err, keySeg(k))
+	}
+	if err := elem(e, ev); err != nil {
+		return e.pathError(err, keySeg(k))
+	}
+	return nil
+}
+
+// encField is a compiled plan for a struct field.
+type encField struct {
+	index []int
+	name  string
+	bit   int
+	enc   encFunc
+}
+
+// fieldEncoder returns an encoding plan for struct field f which follows
+// encoding options set in its' tag.
+func fieldEncoder(f structField) encFunc {
+	switch {
+	case f.embeddedPtr:
+		return newEmbeddedPtrEncoder(f.typ)
+	case f.tag.packed:
+		return newPackedEncoder(f.typ)
+	case !f.tag.intsSet:
+	case isIntType(f.typ):
+		return newIntEncoder(f.typ, f.tag.ints)
+	case !isByteType(f.typ.Elem()):
+		elem := newIntEncoder(f.typ.Elem(), f.tag.ints)
+		if f.typ.Kind() == reflect.Array {
+			return arrayEncoder(elem, nil)
+		}
+		return sliceEncoder(elem, nil)
+	}
+	return encPlanFor(f.typ)
+}
+
+// newStructEncoder returns a plan that writes exported and included fields
+// of a struct in the order defined by structFields. If the struct has
+// omitempty fields a bitmap of fields that are not empty is written first.
+func newStructEncoder(t reflect.Type) encFunc {
+	sf, omit, err := structFields(t)
+	if err != nil {
+		return func(e *Encoder, v reflect.Value) error { return err }
+	}
+	fields := make([]encField, 0, len(sf))
+	for _, f := range sf {
+		fields = append(fields, encField{f.index, f.name, f.bit, fieldEncoder(f)})
+	}
+	// Unexported fields are accessible only through an addressable struct.
+	unexported := hasUnexported(t, sf)
+	return func(e *Encoder, v reflect.Value) (err error) {
+		if unexported && !v.CanAddr() {
+			v = addressable(v)
+		}
+		if omit > 0 {
+			if err = e.writeBitmap(v, fields, omit); err != nil {
+				return
+			}
+		}
+		for _, f := range fields {
+			fv := fieldValue(v, f.index)
+			if f.bit >= 0 && isEmptyValue(fv) {
+				continue
+			}
+			if err = f.enc(e, fv); err != nil {
+				return e.pathError(err, fieldSeg(f.name))
+			}
+		}
+		return
+	}
+}
+
+// writeBitmap writes a bitmap of omitempty fields of struct v that are not
+// empty. omit is the number of omitempty fields.
+func (e *Encoder) writeBitmap(v reflect.Value, fields []encField, omit int) error {
+	var bm []byte
+	if n := (omit + 7) / 8; n <= len(e.buf) {
+		bm = e.buf[:n]
+		for i := range bm {
+			bm[i] = 0
+		}
+	} else {
+		bm = make([]byte, n)
+	}
+	for _, f := range fields {
+		if f.bit >= 0 && !isEmptyValue(fieldValue(v, f.index)) {
+			bm[f.bit/8] |= 1 << uint(f.bit%8)
+		}
+	}
+	return e.write(bm)
+}
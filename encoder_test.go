@@ -0,0 +1,326 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// plainWriter hides any io.ByteWriter implementation of the wrapped writer.
+type plainWriter struct{ io.Writer }
+
+// plainReader hides any io.ByteReader implementation of the wrapped reader.
+type plainReader struct{ io.Reader }
+
+type TreeNode struct {
+	Value    int
+	Children []TreeNode
+}
+
+func TestEncoderDecoder(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(plainWriter{buf})
+	out := make([]BaseTypes, 100)
+	for i := range out {
+		out[i].init()
+		out[i].IntField = i
+		if err := enc.Encode(&out[i]); err != nil {
+			t.Fatal("Encode failed", err)
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal("Flush failed", err)
+	}
+	dec := NewDecoder(plainReader{buf})
+	for i := range out {
+		in := BaseTypes{}
+		if err := dec.Decode(&in); err != nil {
+			t.Fatal("Decode failed", err)
+		}
+		if !reflect.DeepEqual(in, out[i]) {
+			t.Fatalf("Encode/Decode missmatch: in\n%v, out:\n%v\n", in, out[i])
+		}
+	}
+}
+
+func TestEncoderMatchesWrite(t *testing.T) {
+	out := PointerTypes{}
+	out.init()
+	out.PStructField.MapField = nil
+	wbuf := bytes.NewBuffer(nil)
+	if err := Write(wbuf, out); err != nil {
+		t.Fatal("Write failed", err)
+	}
+	ebuf := bytes.NewBuffer(nil)
+	enc := NewEncoder(ebuf)
+	if err := enc.Encode(out); err != nil {
+		t.Fatal("Encode failed", err)
+	}
+	if !bytes.Equal(wbuf.Bytes(), ebuf.Bytes()) {
+		t.Fatalf("Write/Encode missmatch: write\n%v, encode:\n%v\n", wbuf.Bytes(), ebuf.Bytes())
+	}
+}
+
+func TestEncoderRecursiveType(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	out := TreeNode{1, []TreeNode{{2, nil}, {3, []TreeNode{{4, nil}}}}}
+	if err := NewEncoder(buf).Encode(out); err != nil {
+		t.Fatal("Encode failed", err)
+	}
+	in := TreeNode{}
+	if err := NewDecoder(buf).Decode(&in); err != nil {
+		t.Fatal("Decode failed", err)
+	}
+	if in.Children[1].Children[0].Value != 4 {
+		t.Fatalf("Encode/Decode missmatch: in\n%v, out:\n%v\n", in, out)
+	}
+}
+
+func BenchmarkEncoder(b *testing.B) {
+	b.StopTimer()
+	in := BaseTypes{}
+	in.init()
+	enc := NewEncoder(plainWriter{ioutil.Discard})
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		enc.Encode(&in)
+	}
+	enc.Flush()
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	b.StopTimer()
+	in := BaseTypes{}
+	in.init()
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	for i := 0; i < b.N; i++ {
+		enc.Encode(&in)
+	}
+	dec := NewDecoder(buf)
+	var out BaseTypes
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		dec.Decode(&out)
+	}
+}
+
+type NilPreserveTypes struct {
+	NilTypes
+	PStructField *BaseTypes
+	PPointer     **int
+	SliceField   []int
+	MapField     map[string]int
+	EmptySlice   []int
+	EmptyMap     map[string]int
+}
+
+func TestNilPreserve(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	n := 0
+	pn := &n
+	out := NilPreserveTypes{
+		PPointer:   &pn,
+		EmptySlice: []int{},
+		EmptyMap:   map[string]int{},
+	}
+	enc := NewEncoderWithOptions(buf, EncoderOptions{NilMode: NilPreserve})
+	if err := enc.Encode(out); err != nil {
+		t.Fatal("Encode nil preserve failed", err)
+	}
+	in := NilPreserveTypes{}
+	in.PStructField = &BaseTypes{}
+	in.SliceField = []int{1}
+	dec := NewDecoderWithOptions(buf, DecoderOptions{NilMode: NilPreserve})
+	if err := dec.Decode(&in); err != nil {
+		t.Fatal("Decode nil preserve failed", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("Encode/Decode nil preserve missmatch: in\n%#v, out:\n%#v\n", in, out)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("%d bytes left unread", buf.Len())
+	}
+}
+
+func TestNilPreserveDefault(t *testing.T) {
+	defer func(eo EncoderOptions, do DecoderOptions) {
+		DefaultEncoderOptions, DefaultDecoderOptions = eo, do
+	}(DefaultEncoderOptions, DefaultDecoderOptions)
+	DefaultEncoderOptions.NilMode = NilPreserve
+	DefaultDecoderOptions.NilMode = NilPreserve
+
+	buf := bytes.NewBuffer(nil)
+	out := NilTypes{}
+	if err := WriteStruct(buf, out); err != nil {
+		t.Fatal("WriteStruct nil preserve failed", err)
+	}
+	in := NilTypes{}
+	if err := ReadStruct(buf, &in); err != nil {
+		t.Fatal("ReadStruct nil preserve failed", err)
+	}
+	if in.PBoolField != nil || in.PStringField != nil || in.PMapField != nil {
+		t.Fatalf("Read/Write nil preserve missmatch: in\n%#v, out:\n%#v\n", in, out)
+	}
+}
+
+type (
+	NamedByte  uint8
+	NamedBytes []NamedByte
+)
+
+type ByteTypes struct {
+	Bytes  []byte
+	Named  NamedBytes
+	Array  [4]byte
+	Arrays [2][3]NamedByte
+	Nil    []byte
+}
+
+func TestEncoderBytes(t *testing.T) {
+	p := make([]byte, 1<<20)
+	for i := range p {
+		p[i] = byte(i)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := Write(buf, p); err != nil {
+		t.Fatal("Write failed", err)
+	}
+	if buf.Len() != len(p)+4 {
+		t.Fatalf("expected %d bytes written, got %d", len(p)+4, buf.Len())
+	}
+	var in []byte
+	if err := Read(plainReader{buf}, &in); err != nil {
+		t.Fatal("Read failed", err)
+	}
+	if !bytes.Equal(in, p) {
+		t.Fatal("Read/Write missmatch")
+	}
+	out := ByteTypes{
+		Bytes:  []byte("bytes"),
+		Named:  NamedBytes{1, 2, 255},
+		Array:  [4]byte{4, 3, 2, 1},
+		Arrays: [2][3]NamedByte{{1, 2, 3}, {128, 129, 130}},
+	}
+	for _, opts := range []EncoderOptions{{}, {SelfDescribing: true}} {
+		buf.Reset()
+		// Unaddressable value.
+		if err := NewEncoderWithOptions(buf, opts).Encode(out); err != nil {
+			t.Fatal("Encode failed", err)
+		}
+		var in ByteTypes
+		dec := NewDecoderWithOptions(buf, DecoderOptions{SelfDescribing: opts.SelfDescribing})
+		if err := dec.Decode(&in); err != nil {
+			t.Fatal("Decode failed", err)
+		}
+		out.Nil = []byte{}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("Encode/Decode missmatch: in\n%v, out:\n%v\n", in, out)
+		}
+		out.Nil = nil
+	}
+}
+
+func TestEncoderBytesSelfDescribing(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoderWithOptions(buf, EncoderOptions{SelfDescribing: true})
+	for _, val := range []interface{}{
+		[4]byte{1, 2, 3, 4}, [4]byte{1, 2, 3, 4}, []byte{5, 6}, RecordItem{"a", 1},
+	} {
+		if err := enc.Encode(val); err != nil {
+			t.Fatal("Encode failed", err)
+		}
+	}
+	dec := NewDecoderWithOptions(buf, DecoderOptions{SelfDescribing: true})
+	var (
+		short [2]byte
+		long  [6]NamedByte
+		elems []uint16
+		item  struct{ Price float64 }
+	)
+	for _, val := range []interface{}{&short, &long, &elems, &item} {
+		if err := dec.Decode(val); err != nil {
+			t.Fatalf("%T: Decode failed: %v", val, err)
+		}
+	}
+	if short != [2]byte{1, 2} || long != [6]NamedByte{1, 2, 3, 4} ||
+		!reflect.DeepEqual(elems, []uint16{5, 6}) || item.Price != 1 {
+		t.Fatalf("Encode/Decode missmatch: %v, %v, %v, %v", short, long, elems, item)
+	}
+}
+
+func BenchmarkEncoderBytes(b *testing.B) {
+	p := make([]byte, 1<<20)
+	enc := NewEncoder(ioutil.Discard)
+	b.SetBytes(int64(len(p)))
+	for i := 0; i < b.N; i++ {
+		enc.Encode(p)
+	}
+}
+
+func BenchmarkDecoderBytes(b *testing.B) {
+	buf := bytes.NewBuffer(nil)
+	if err := Write(buf, make([]byte, 1<<20)); err != nil {
+		b.Fatal("Write failed", err)
+	}
+	data := buf.Bytes()
+	b.SetBytes(int64(len(data)))
+	var in []byte
+	for i := 0; i < b.N; i++ {
+		Read(bytes.NewReader(data), &in)
+	}
+}
+
+type FloatTypes struct {
+	F  float32
+	C  complex64
+	Fs []float32
+	D  float64
+}
+
+func TestEncoderFloatWidth(t *testing.T) {
+	out := FloatTypes{1.5, complex(2.5, -3.5), []float32{4.5, 5.5}, 6.5}
+	for _, test := range []struct {
+		opts EncoderOptions
+		size int
+	}{
+		{EncoderOptions{}, 4 + 8 + 1 + 8 + 8},
+		{EncoderOptions{WideFloats: true}, 8 + 16 + 1 + 16 + 8},
+	} {
+		buf := bytes.NewBuffer(nil)
+		if err := NewEncoderWithOptions(buf, test.opts).Encode(out); err != nil {
+			t.Fatal("Encode failed", err)
+		}
+		if buf.Len() != test.size {
+			t.Fatalf("%+v: expected %d bytes, got %d", test.opts, test.size, buf.Len())
+		}
+		var in FloatTypes
+		dec := NewDecoderWithOptions(buf, DecoderOptions{WideFloats: test.opts.WideFloats})
+		if err := dec.Decode(&in); err != nil {
+			t.Fatal("Decode failed", err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("%+v: Encode/Decode missmatch: %v, %v", test.opts, in, out)
+		}
+		// Self describing streams record the choice.
+		buf.Reset()
+		test.opts.SelfDescribing = true
+		if err := NewEncoderWithOptions(buf, test.opts).Encode(out); err != nil {
+			t.Fatal("Encode self describing failed", err)
+		}
+		in = FloatTypes{}
+		dec = NewDecoderWithOptions(buf, DecoderOptions{SelfDescribing: true})
+		if err := dec.Decode(&in); err != nil {
+			t.Fatal("Decode self describing failed", err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("%+v: Encode/Decode self describing missmatch: %v, %v", test.opts, in, out)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// DecodeError is returned when reading a value fails. It wraps the error
+// that caused it and matches ErrBinaryEx using errors.Is regardless of it.
+type DecodeError struct {
+	// Type is the type of the top level value being read.
+	Type reflect.Type
+	// Path is the path to the value that failed to read starting with the
+	// name of Type, like Order.Items[3].Price.
+	Path string
+	// Offset is the stream offset at which reading failed.
+	Offset int64
+	// Err is the error that caused the failure.
+	Err error
+
+	// segs holds path segments in reverse order while the error propagates.
+	segs []string
+}
+
+// Error implements error.
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("binaryex: reading %s at offset %d: %v", e.Path, e.Offset, e.Err)
+}
+
+// Unwrap returns the error that caused the failure.
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// Is returns true if target is ErrBinaryEx.
+func (e *DecodeError) Is(target error) bool { return target == ErrBinaryEx }
+
+// EncodeError is returned when writing a value fails. It wraps the error
+// that caused it and matches ErrBinaryEx using errors.Is regardless of it.
+type EncodeError struct {
+	// Type is the type of the top level value being written.
+	Type reflect.Type
+	// Path is the path to the value that failed to write starting with the
+	// name of Type, like Order.Items[3].Price.
+	Path string
+	// Offset is the stream offset at which writing failed.
+	Offset int64
+	// Err is the error that caused the failure.
+	Err error
+
+	// segs holds path segments in reverse order while the error propagates.
+	segs []string
+}
+
+// Error implements error.
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("binaryex: writing %s at offset %d: %v", e.Path, e.Offset, e.Err)
+}
+
+// Unwrap returns the error that caused the failure.
+func (e *EncodeError) Unwrap() error { return e.Err }
+
+// Is returns true if target is ErrBinaryEx.
+func (e *EncodeError) Is(target error) bool { return target == ErrBinaryEx }
+
+// fieldSeg returns the path segment of struct field name.
+func fieldSeg(name string) string { return "." + name }
+
+// indexSeg returns the path segment of array or slice index i.
+func indexSeg(i int) string { return "[" + strconv.Itoa(i) + "]" }
+
+// keySeg returns the path segment of map value under key k.
+func keySeg(k reflect.Value) string {
+	if k.Kind() == reflect.String {
+		return "[" + strconv.Quote(k.String()) + "]"
+	}
+	if !k.CanInterface() {
+		return "[" + k.Type().String() + "]"
+	}
+	return fmt.Sprintf("[%v]", k.Interface())
+}
+
+// mapKeySeg returns the path segment of the key of i-th map entry.
+func mapKeySeg(i int) string { return "[key " + strconv.Itoa(i) + "]" }
+
+// typeSeg returns the path segment of a value of type t held by an
+// interface.
+func typeSeg(t reflect.Type) string { return ".(" + t.String() + ")" }
+
+// joinPath returns the path of a value within a top level value of type t
+// from path segments in reverse order.
+func joinPath(t reflect.Type, segs []string) string {
+	var sb strings.Builder
+	if t == nil {
+		sb.WriteString("value")
+	} else {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Name() != "" {
+			sb.WriteString(t.Name())
+		} else {
+			sb.WriteString(t.String())
+		}
+	}
+	for i := len(segs) - 1; i >= 0; i-- {
+		sb.WriteString(segs[i])
+	}
+	return sb.String()
+}
+
+// pathError adds path segment seg to err, wrapping it in a DecodeError
+// first if it is not one already.
+func (d *Decoder) pathError(err error, seg string) error {
+	de, ok := err.(*DecodeError)
+	if !ok || de.Type != nil {
+		de = &DecodeError{Offset: d.r.n, Err: err}
+	}
+	de.segs = append(de.segs, seg)
+	return de
+}
+
+// decodeError returns err which occured reading a top level value of type
+// t wrapped in a DecodeError or nil if err is nil. An io.EOF before any
+// data of the value was read is returned as is and an io.EOF after it is
+// reported as io.ErrUnexpectedEOF.
+func (d *Decoder) decodeError(err error, t reflect.Type) error {
+	if err == nil {
+		return nil
+	}
+	de, ok := err.(*DecodeError)
+	if !ok || de.Type != nil {
+		de = &DecodeError{Offset: d.r.n, Err: err}
+	}
+	if de.Err == io.EOF {
+		if d.r.n == d.start {
+			return io.EOF
+		}
+		de.Err = io.ErrUnexpectedEOF
+	}
+	de.Type = t
+	de.Path = joinPath(t, de.segs)
+	de.segs = nil
+	return de
+}
+
+// pathError adds path segment seg to err, wrapping it in an EncodeError
+// first if it is not one already.
+func (e *Encoder) pathError(err error, seg string) error {
+	ee, ok := err.(*EncodeError)
+	if !ok || ee.Type != nil {
+		ee = &EncodeError{Offset: e.n, Err: err}
+	}
+	ee.segs = append(ee.segs, seg)
+	return ee
+}
+
+// encodeError returns err which occured writing a top level value of type t
+// wrapped in an EncodeError or nil if err is nil.
+func (e *Encoder) encodeError(err error, t reflect.Type) error {
+	if err == nil {
+		return nil
+	}
+	ee, ok := err.(*EncodeError)
+	if !ok || ee.Type != nil {
+		ee = &EncodeError{Offset: e.n, Err: err}
+	}
+	ee.Type = t
+	ee.Path = joinPath(t, ee.segs)
+	ee.segs = nil
+	return ee
+}
+
+// panicError is a panic recovered while writing or reading a value.
+type panicError struct {
+	val interface{}
+}
+
+// Error implements error.
+func (pe *panicError) Error() string {
+	return fmt.Sprintf("%v: panic: %v", ErrInvalidArgument, pe.val)
+}
+
+// Unwrap returns ErrInvalidArgument.
+func (pe *panicError) Unwrap() error { return ErrInvalidArgument }
+
+// recoverError stores a recovered panic into err. It must be deferred.
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		*err = &panicError{r}
+	}
+}
+
+// valueType returns the type of v or nil if v is not valid.
+func valueType(v reflect.Value) reflect.Type {
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Type()
+}
+
+// checkArg returns ErrInvalidArgument if v is not valid,
+// ErrUnadressableValue if settable is true and v can not be set or
+// ErrUnsupportedValue if kinds are given and v is of none of them.
+func checkArg(v reflect.Value, settable bool, kinds ...reflect.Kind) error {
+	if !v.IsValid() {
+		return ErrInvalidArgument
+	}
+	if settable && !v.CanSet() {
+		return ErrUnadressableValue
+	}
+	if len(kinds) == 0 {
+		return nil
+	}
+	for _, k := range kinds {
+		if v.Kind() == k {
+			return nil
+		}
+	}
+	return ErrUnsupportedValue
+}
@@ -0,0 +1,192 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type OrderItem struct {
+	Name  string
+	Price float64
+}
+
+type Order struct {
+	ID    int
+	Items []OrderItem
+	Meta  map[string]Shape
+}
+
+func (o *Order) init() {
+	o.ID = 1
+	for _, name := range []string{"a", "b", "c", "d"} {
+		o.Items = append(o.Items, OrderItem{name, 1.5})
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	var out Order
+	out.init()
+	data, err := Marshal(out)
+	if err != nil {
+		t.Fatal("Marshal failed", err)
+	}
+	// Cut the stream within Items[3].Price, after its' first byte.
+	n := len(data) - 1 - 7
+	var in Order
+	err = Unmarshal(data[:n], &in)
+	var de *DecodeError
+	if !errors.As(err, &de) {
+		t.Fatalf("expected *DecodeError, got %v", err)
+	}
+	if de.Path != "Order.Items[3].Price" || de.Offset != int64(n) ||
+		de.Type != reflect.TypeOf(Order{}) {
+		t.Fatalf("unexpected DecodeError: %+v", de)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) || !errors.Is(err, ErrBinaryEx) {
+		t.Fatalf("DecodeError does not match: %v", err)
+	}
+	if err := Unmarshal(nil, &in); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestDecodeErrorSelfDescribing(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoderWithOptions(buf, EncoderOptions{SelfDescribing: true})
+	var o Order
+	o.init()
+	o.Meta = map[string]Shape{"shape": Rect{1, 2}}
+	if err := enc.Encode(o); err != nil {
+		t.Fatal("Encode failed", err)
+	}
+	var in struct {
+		Meta map[string]Shape
+		// Name of OrderItem is read into an int.
+		Items []struct{ Name int }
+	}
+	dec := NewDecoderWithOptions(buf, DecoderOptions{SelfDescribing: true})
+	err := dec.Decode(&in)
+	var de *DecodeError
+	if !errors.As(err, &de) || de.Path != "struct { Meta map[string]binaryex.Shape; Items []struct { Name int } }.Items[0].Name" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !errors.Is(err, ErrIncompatibleType) || !errors.Is(err, ErrBinaryEx) {
+		t.Fatalf("DecodeError does not match: %v", err)
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	var o Order
+	o.init()
+	o.Meta = map[string]Shape{"bad": Unregistered{}}
+	buf := bytes.NewBuffer(nil)
+	err := Write(buf, &o)
+	var ee *EncodeError
+	if !errors.As(err, &ee) {
+		t.Fatalf("expected *EncodeError, got %v", err)
+	}
+	if ee.Path != `Order.Meta["bad"]` || ee.Offset != int64(buf.Len()) ||
+		ee.Type != reflect.TypeOf(o) {
+		t.Fatalf("unexpected EncodeError: %+v", ee)
+	}
+	if !errors.Is(err, ErrUnregisteredType) || !errors.Is(err, ErrBinaryEx) {
+		t.Fatalf("EncodeError does not match: %v", err)
+	}
+	if _, err := Marshal(struct{ C []chan int }{[]chan int{nil}}); !errors.As(err, &ee) ||
+		ee.Path != "struct { C []chan int }.C[0]" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// PanicMarshaler panics when marshaled.
+type PanicMarshaler struct{}
+
+func (PanicMarshaler) MarshalBinary() ([]byte, error) {
+	panic("marshal")
+}
+
+func (*PanicMarshaler) UnmarshalBinary(p []byte) error {
+	return nil
+}
+
+func TestInvalidArgument(t *testing.T) {
+	var (
+		b bool
+		s string
+		n int
+	)
+	for i, test := range []struct {
+		err    error
+		target error
+		typ    reflect.Type
+	}{
+		{ReadBool(bytes.NewBuffer([]byte{1}), 5), ErrUnadressableValue, reflect.TypeOf(0)},
+		{ReadBool(bytes.NewBuffer([]byte{1}), &n), ErrUnsupportedValue, reflect.TypeOf(0)},
+		{ReadNumber(bytes.NewBuffer([]byte{1}), &s), ErrUnsupportedValue, reflect.TypeOf("")},
+		{ReadStruct(bytes.NewBuffer([]byte{1}), &b), ErrUnsupportedValue, reflect.TypeOf(false)},
+		{Read(bytes.NewBuffer([]byte{1}), nil), ErrInvalidArgument, nil},
+		{Read(nil, &n), ErrInvalidArgument, reflect.TypeOf(0)},
+		{Unmarshal([]byte{1}, n), ErrUnadressableValue, reflect.TypeOf(0)},
+		{WriteStruct(bytes.NewBuffer(nil), 3), ErrUnsupportedValue, reflect.TypeOf(0)},
+		{WriteNumber(bytes.NewBuffer(nil), "3"), ErrUnsupportedValue, reflect.TypeOf("")},
+		{WriteMap(bytes.NewBuffer(nil), nil), ErrInvalidArgument, nil},
+		{Write(nil, 3), ErrInvalidArgument, reflect.TypeOf(0)},
+		{Write((*bytes.Buffer)(nil), 3), ErrInvalidArgument, reflect.TypeOf(0)},
+		{NewEncoder(nil).Encode(3), ErrInvalidArgument, reflect.TypeOf(0)},
+		{NewDecoder(nil).Decode(&n), ErrInvalidArgument, reflect.TypeOf(0)},
+		{NewDecoder(bytes.NewBuffer([]byte{1})).Skip(nil), ErrInvalidArgument, nil},
+		{NewEncoder(bytes.NewBuffer(nil)).WriteInt(1, 3), ErrInvalidArgument, nil},
+	} {
+		if !errors.Is(test.err, test.target) {
+			t.Fatalf("%d: expected %v, got %v", i, test.target, test.err)
+		}
+		if test.typ == nil {
+			continue
+		}
+		var ee *EncodeError
+		var de *DecodeError
+		switch {
+		case errors.As(test.err, &ee):
+			if ee.Type != test.typ {
+				t.Fatalf("%d: expected type %v, got %v", i, test.typ, ee.Type)
+			}
+		case errors.As(test.err, &de):
+			if de.Type != test.typ {
+				t.Fatalf("%d: expected type %v, got %v", i, test.typ, de.Type)
+			}
+		default:
+			t.Fatalf("%d: expected *EncodeError or *DecodeError, got %v", i, test.err)
+		}
+	}
+}
+
+func TestRecoverPanic(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	err := enc.Encode(struct{ P []PanicMarshaler }{[]PanicMarshaler{{}}})
+	var ee *EncodeError
+	if !errors.As(err, &ee) || !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("expected EncodeError wrapping ErrInvalidArgument, got %v", err)
+	}
+	if ee.Type != reflect.TypeOf(struct{ P []PanicMarshaler }{}) {
+		t.Fatalf("unexpected type %v", ee.Type)
+	}
+	buf.Reset()
+	if err := enc.Encode(Order{ID: 1}); err != nil {
+		t.Fatal("Encode after panic failed", err)
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal("Flush failed", err)
+	}
+	var in Order
+	if err := NewDecoder(buf).Decode(&in); err != nil || in.ID != 1 {
+		t.Fatalf("Decode after panic failed: %v, %v", err, in)
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// TagName is the name of the struct field tag binaryex reads field options
+// from.
+//
+// The tag value is a comma separated list whose first element is either
+// empty, a "-" which excludes the field from encoding, a positive integer
+// ordinal of the field or the first option. Fields with an ordinal are
+// written in the order of their ordinals, followed by fields without one in
+// the order of their declaration. Remaining elements are options:
+//
+// "include" writes an unexported field, which is otherwise skipped. Its'
+// value is read and set through package unsafe. Exported fields of an
+// embedded struct of an unexported type are written only if the embedded
+// field is included, and its' unexported fields only if they are included
+// themselves.
+//
+// "omitempty" skips writing the field if it is empty, that is a zero value
+// or an empty slice or map. A struct with omitempty fields is prefixed by a
+// bitmap of its' omitempty fields that were written and those that were not
+// are set to zero values when read.
+//
+// "packed" writes a field that is an array or a slice of numbers as a
+// contiguous block of fixed width numbers, as the Packed encoder option
+// does for all such values.
+//
+// "zigzag", "varint", "fixed" and "fixedbe" write a field that is an int or
+// an uint, or an array or a slice of them, using IntZigZag, IntVarint,
+// IntFixed or IntFixedBE encoding regardless of the IntEncoding option.
+// They can not be combined with "packed" or with each other.
+//
+// "flatten" writes fields of an embedded struct, which is not a pointer, in
+// place of it as if they were declared in the outer struct, regardless of
+// methods of the embedded type. Their ordinals then share the ordinal space
+// of the outer struct and a self describing stream matches them by name
+// among outer fields, so fields can move in and out of the embedded struct.
+// Names of flattened fields must not collide with names of other fields.
+// "nest", the default, writes an embedded struct as a single field named by
+// its' type. Neither can be combined with other options but "include".
+//
+// Embedded pointers are always written with a presence marker, as in
+// NilPreserve mode, so that nil embedded pointers are read as nil in any
+// NilMode. In NilLegacy mode this differs from older versions, which wrote
+// them as other pointers, and their data can not be read.
+//
+// Examples:
+//
+//	Cache   []byte    `binaryex:"-"`
+//	state   int       `binaryex:"include"`
+//	secret  string    `binaryex:"3,include"`
+//	ID      uint64    `binaryex:"1"`
+//	Comment string    `binaryex:"2,omitempty"`
+//	Notes   string    `binaryex:",omitempty"`
+//	Samples []float64 `binaryex:",packed"`
+//	Hash    uint64    `binaryex:",fixed"`
+//	Base              `binaryex:"flatten"`
+const TagName = "binaryex"
+
+// fieldTag holds parsed struct field tag options.
+type fieldTag struct {
+	skip      bool
+	include   bool
+	flatten   bool
+	nest      bool
+	ordinal   int
+	omitEmpty bool
+	packed    bool
+	ints      IntEncoding
+	intsSet   bool
+}
+
+// intEncodings maps tag options to integer encodings.
+var intEncodings = map[string]IntEncoding{
+	"zigzag":  IntZigZag,
+	"varint":  IntVarint,
+	"fixed":   IntFixed,
+	"fixedbe": IntFixedBE,
+}
+
+// parseTag parses a binaryex struct field tag.
+func parseTag(tag string) (ft fieldTag, err error) {
+	if tag == "" {
+		return
+	}
+	opts := strings.Split(tag, ",")
+	switch opts[0] {
+	case "":
+		opts = opts[1:]
+	case "-":
+		if len(opts) > 1 {
+			return ft, ErrInvalidTag
+		}
+		ft.skip = true
+		return
+	default:
+		// The first element is an option if it is not an ordinal.
+		if n, convErr := strconv.Atoi(opts[0]); convErr == nil {
+			if n < 1 {
+				return ft, ErrInvalidTag
+			}
+			ft.ordinal, opts = n, opts[1:]
+		}
+	}
+	for _, opt := range opts {
+		switch opt {
+		case "include":
+			ft.include = true
+		case "flatten":
+			ft.flatten = true
+		case "nest":
+			ft.nest = true
+		case "omitempty":
+			ft.omitEmpty = true
+		case "packed":
+			ft.packed = true
+		default:
+			enc, ok := intEncodings[opt]
+			if !ok || ft.intsSet {
+				return ft, ErrInvalidTag
+			}
+			ft.ints, ft.intsSet = enc, true
+		}
+	}
+	if (ft.flatten || ft.nest) && (ft.flatten == ft.nest || ft.ordinal != 0 ||
+		ft.omitEmpty || ft.packed || ft.intsSet) {
+		return ft, ErrInvalidTag
+	}
+	return
+}
+
+// structField describes an encoded struct field.
+type structField struct {
+	// index is the index sequence of the field in the struct, longer than
+	// one for fields of flattened embedded structs.
+	index []int
+	// name is the name of the field.
+	name string
+	// typ is the type of the field.
+	typ reflect.Type
+	// tag holds options parsed from field tag.
+	tag fieldTag
+	// bit is the index of the field in the bitmap of present omitempty
+	// fields or -1 if the field is not omitempty.
+	bit int
+	// embeddedPtr is true for embedded pointers which are written with a
+	// presence marker.
+	embeddedPtr bool
+}
+
+// structFields returns fields of struct type t to be encoded in the order
+// they are encoded and the number of omitempty fields among them.
+func structFields(t reflect.Type) (fields []structField, omit int, err error) {
+	if fields, err = declaredFields(t); err != nil {
+		return nil, 0, err
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		oi, oj := fields[i].tag.ordinal, fields[j].tag.ordinal
+		if oi == 0 || oj == 0 {
+			return oj == 0 && oi != 0
+		}
+		return oi < oj
+	})
+	names := make(map[string]bool, len(fields))
+	for i := range fields {
+		if i > 0 && fields[i].tag.ordinal != 0 &&
+			fields[i].tag.ordinal == fields[i-1].tag.ordinal {
+			return nil, 0, ErrInvalidTag
+		}
+		if names[fields[i].name] {
+			return nil, 0, ErrInvalidTag
+		}
+		names[fields[i].name] = true
+		if fields[i].tag.omitEmpty {
+			fields[i].bit = omit
+			omit++
+		}
+	}
+	return
+}
+
+// declaredFields returns fields of struct type t to be encoded in the order
+// of their declaration, with fields of flattened embedded structs in place
+// of them.
+func declaredFields(t reflect.Type) (fields []structField, err error) {
+	fields = make([]structField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		ft, tagErr := parseTag(f.Tag.Get(TagName))
+		if f.PkgPath != "" && (tagErr != nil || !ft.include) {
+			continue
+		}
+		if tagErr != nil {
+			return nil, tagErr
+		}
+		if ft.skip {
+			continue
+		}
+		if ft.packed && (!isPackable(f.Type) || ft.intsSet) {
+			return nil, ErrInvalidTag
+		}
+		if ft.intsSet && !isIntType(f.Type) && !isIntSequence(f.Type) {
+			return nil, ErrInvalidTag
+		}
+		if (ft.flatten || ft.nest) && !f.Anonymous {
+			return nil, ErrInvalidTag
+		}
+		if ft.flatten {
+			if f.Type.Kind() != reflect.Struct {
+				return nil, ErrInvalidTag
+			}
+			var inner []structField
+			if inner, err = declaredFields(f.Type); err != nil {
+				return nil, err
+			}
+			for _, fi := range inner {
+				fi.index = append([]int{i}, fi.index...)
+				fields = append(fields, fi)
+			}
+			continue
+		}
+		fields = append(fields, structField{
+			index:       []int{i},
+			name:        f.Name,
+			typ:         f.Type,
+			tag:         ft,
+			bit:         -1,
+			embeddedPtr: f.Anonymous && f.Type.Kind() == reflect.Ptr,
+		})
+	}
+	return
+}
+
+// isEmptyValue returns true if v is a zero value or an empty slice or map.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// hasUnexported returns true if any of fields of struct type t is
+// unexported.
+func hasUnexported(t reflect.Type, fields []structField) bool {
+	for _, f := range fields {
+		ft := t
+		for _, i := range f.index {
+			sf := ft.Field(i)
+			if sf.PkgPath != "" {
+				return true
+			}
+			ft = sf.Type
+		}
+	}
+	return false
+}
+
+// fieldValue returns the field of struct v at index sequence index. An
+// unexported field of an addressable v is accessed through unsafe so that
+// it can be set and passed to marshaler methods.
+func fieldValue(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		fv := v.Field(i)
+		if !fv.CanSet() && v.CanAddr() {
+			fv = reflect.NewAt(fv.Type(), unsafe.Pointer(fv.UnsafeAddr())).Elem()
+		}
+		v = fv
+	}
+	return v
+}
+
+// addressable returns an addressable copy of v.
+func addressable(v reflect.Value) reflect.Value {
+	pv := reflect.New(v.Type()).Elem()
+	pv.Set(v)
+	return pv
+}
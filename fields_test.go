@@ -0,0 +1,209 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type OrderedV1 struct {
+	Name  string  `binaryex:"1"`
+	Count int     `binaryex:"2"`
+	Score float64 `binaryex:"3"`
+	Cache []byte  `binaryex:"-"`
+}
+
+type OrderedV2 struct {
+	Score float64 `binaryex:"3"`
+	Extra string
+	Name  string `binaryex:"1"`
+	Count int    `binaryex:"2"`
+}
+
+type OmitEmptyTypes struct {
+	Name    string            `binaryex:",omitempty"`
+	Count   int               `binaryex:",omitempty"`
+	Always  int               `binaryex:"1"`
+	Items   []string          `binaryex:",omitempty"`
+	Attrs   map[string]string `binaryex:",omitempty"`
+	Pointer *int              `binaryex:",omitempty"`
+}
+
+type InvalidTagTypes struct {
+	Field int `binaryex:"first"`
+}
+
+type DuplicateOrdinalTypes struct {
+	A int `binaryex:"1"`
+	B int `binaryex:"1"`
+}
+
+type privateInner struct {
+	Visible string
+	hidden  int `binaryex:"include"`
+	ignored int
+}
+
+type PrivateTypes struct {
+	Public       string
+	privateInner `binaryex:"include"`
+	count        int            `binaryex:"include"`
+	enum         TextEnum       `binaryex:"1,include"`
+	point        GobPoint       `binaryex:"include"`
+	inner        *privateInner  `binaryex:"include,omitempty"`
+	attrs        map[string]int `binaryex:"include"`
+	ignored      int
+}
+
+func (pt *PrivateTypes) init() {
+	pt.Public = "public"
+	pt.privateInner = privateInner{"visible", 1, 2}
+	pt.count = 3
+	pt.enum = 4
+	pt.point = GobPoint{5, 6}
+	pt.inner = &privateInner{"inner", 7, 8}
+	pt.attrs = map[string]int{"a": 9}
+	pt.ignored = 10
+}
+
+func TestTagInclude(t *testing.T) {
+	var out PrivateTypes
+	out.init()
+	for _, sd := range []bool{false, true} {
+		buf := bytes.NewBuffer(nil)
+		enc := NewEncoderWithOptions(buf, EncoderOptions{SelfDescribing: sd})
+		// Written unaddressable, then addressable.
+		if err := enc.Encode(out); err != nil {
+			t.Fatal("Encode failed", err)
+		}
+		if err := enc.Encode(&out); err != nil {
+			t.Fatal("Encode failed", err)
+		}
+		if err := enc.Flush(); err != nil {
+			t.Fatal("Flush failed", err)
+		}
+		dec := NewDecoderWithOptions(buf, DecoderOptions{SelfDescribing: sd})
+		if err := dec.Skip(reflect.TypeOf(out)); err != nil {
+			t.Fatal("Skip failed", err)
+		}
+		var in PrivateTypes
+		if err := dec.Decode(&in); err != nil {
+			t.Fatal("Decode failed", err)
+		}
+		expected := out
+		expected.privateInner.ignored = 0
+		expected.inner = &privateInner{"inner", 7, 0}
+		expected.ignored = 0
+		if !reflect.DeepEqual(in, expected) {
+			t.Fatalf("%t: Encode/Decode missmatch: in\n%+v, out:\n%+v\n", sd, in, expected)
+		}
+	}
+}
+
+func TestTagIncludeOnly(t *testing.T) {
+	// Unexported fields are not written without include.
+	data, err := Marshal(struct {
+		A int
+		b int
+		c privateInner
+	}{1, 2, privateInner{"c", 3, 4}})
+	if err != nil {
+		t.Fatal("Marshal failed", err)
+	}
+	if !bytes.Equal(data, []byte{2}) {
+		t.Fatalf("unexpected encoding % x", data)
+	}
+}
+
+func TestTagSkip(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	out := OrderedV1{"name", 1, 2.5, []byte("cache")}
+	if err := Write(buf, out); err != nil {
+		t.Fatal("Write failed", err)
+	}
+	in := OrderedV1{Cache: []byte("keep")}
+	if err := Read(buf, &in); err != nil {
+		t.Fatal("Read failed", err)
+	}
+	out.Cache = []byte("keep")
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("Read/Write tag skip missmatch: in\n%v, out:\n%v\n", in, out)
+	}
+}
+
+func TestTagOrdinal(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	out := OrderedV2{Score: 2.5, Extra: "extra", Name: "name", Count: 1}
+	if err := Write(buf, out); err != nil {
+		t.Fatal("Write failed", err)
+	}
+	in := OrderedV2{}
+	if err := Read(buf, &in); err != nil {
+		t.Fatal("Read failed", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("Read/Write tag ordinal missmatch: in\n%v, out:\n%v\n", in, out)
+	}
+
+	// Fields with same ordinals are read in the same order regardless of
+	// their declaration order.
+	buf.Reset()
+	v1 := OrderedV1{"name", 1, 2.5, nil}
+	if err := Write(buf, v1); err != nil {
+		t.Fatal("Write failed", err)
+	}
+	if err := Write(buf, ""); err != nil {
+		t.Fatal("Write failed", err)
+	}
+	in = OrderedV2{}
+	if err := Read(buf, &in); err != nil {
+		t.Fatal("Read failed", err)
+	}
+	if in.Name != v1.Name || in.Count != v1.Count || in.Score != v1.Score {
+		t.Fatalf("Read/Write tag ordinal missmatch: in\n%v, out:\n%v\n", in, v1)
+	}
+}
+
+func TestTagOmitEmpty(t *testing.T) {
+	n := 7
+	for _, out := range []OmitEmptyTypes{
+		{},
+		{Always: 1},
+		{Name: "name", Items: []string{"a"}, Pointer: &n},
+		{Count: 2, Attrs: map[string]string{"a": "b"}},
+	} {
+		buf := bytes.NewBuffer(nil)
+		if err := Write(buf, out); err != nil {
+			t.Fatal("Write failed", err)
+		}
+		in := OmitEmptyTypes{Name: "stale", Count: 9, Items: []string{"stale"}}
+		if err := Read(buf, &in); err != nil {
+			t.Fatal("Read failed", err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("Read/Write omitempty missmatch: in\n%v, out:\n%v\n", in, out)
+		}
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := Write(buf, OmitEmptyTypes{}); err != nil {
+		t.Fatal("Write failed", err)
+	}
+	if buf.Len() != 2 {
+		t.Fatalf("expected 2 bytes for empty struct, got %d", buf.Len())
+	}
+}
+
+func TestTagInvalid(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	if err := Write(buf, InvalidTagTypes{}); !errors.Is(err, ErrInvalidTag) {
+		t.Fatalf("expected ErrInvalidTag, got %v", err)
+	}
+	if err := Write(buf, DuplicateOrdinalTypes{}); !errors.Is(err, ErrInvalidTag) {
+		t.Fatalf("expected ErrInvalidTag, got %v", err)
+	}
+}
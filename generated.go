@@ -0,0 +1,329 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import "reflect"
+
+// Marshaler is implemented by types with encoding methods generated by
+// binaryex-gen. Encoders call MarshalBinaryEx instead of reflecting on the
+// value unless BinaryExMarshaler is left out of Marshalers option.
+//
+// MarshalBinaryEx must write the value exactly as an Encoder would write it
+// without the method, so that streams stay readable by Decoders that do not
+// use UnmarshalBinaryEx, like a self describing Decoder or Skip. It should
+// be implemented on the pointer type along with Unmarshaler. Methods
+// promoted from an embedded Marshaler are not called. Neither are methods
+// of structs with fields of bool, number, string or byte slice types for
+// which a codec is registered, as generated methods write them directly.
+type Marshaler interface {
+	MarshalBinaryEx(e *Encoder) error
+}
+
+// Unmarshaler is implemented by types with decoding methods generated by
+// binaryex-gen. Decoders call UnmarshalBinaryEx instead of reflecting on the
+// value unless SelfDescribing option is set.
+type Unmarshaler interface {
+	UnmarshalBinaryEx(d *Decoder) error
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// hasGenerated returns true if pointer to type t implements iface, Marshaler
+// or Unmarshaler, with methods that are not promoted. Embedded fields of
+// structs that declare no such methods of their own are still written using
+// their generated methods.
+func hasGenerated(t, iface reflect.Type) bool {
+	return t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(iface) &&
+		!promoted(t, iface)
+}
+
+// directTypes returns types of fields of struct type t which methods
+// generated by binaryex-gen write directly, without codecs: bools, numbers,
+// strings and byte slices.
+func directTypes(t reflect.Type) (types []reflect.Type) {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	fields, _, err := structFields(t)
+	if err != nil {
+		return nil
+	}
+	for _, f := range fields {
+		switch k := f.typ.Kind(); {
+		case k >= reflect.Bool && k <= reflect.Complex128, k == reflect.String,
+			k == reflect.Slice && f.typ.Elem().Kind() == reflect.Uint8:
+			types = append(types, f.typ)
+		}
+	}
+	return
+}
+
+// newGeneratedEncoder returns a plan that writes values of type t using
+// encMarshalerEx or using refl if a codec is registered globally or with the
+// Encoder for a type generated methods write directly, so that it applies.
+func newGeneratedEncoder(t reflect.Type, refl encFunc) encFunc {
+	direct := directTypes(t)
+	for _, dt := range direct {
+		// Compiling a plan keeps codecs from being registered later.
+		encPlanFor(dt)
+		if _, ok := registeredCodec(dt); ok {
+			return refl
+		}
+	}
+	return func(e *Encoder, v reflect.Value) error {
+		if e.codecs != nil {
+			for _, dt := range direct {
+				if _, ok := e.codecs[dt]; ok {
+					return refl(e, v)
+				}
+			}
+		}
+		return encMarshalerEx(e, v)
+	}
+}
+
+// newGeneratedDecoder returns a plan that reads values of type t using
+// decUnmarshalerEx or using refl if a codec is registered globally or with
+// the Decoder for a type generated methods read directly.
+func newGeneratedDecoder(t reflect.Type, refl decFunc) decFunc {
+	direct := directTypes(t)
+	for _, dt := range direct {
+		decPlanFor(dt)
+		if _, ok := registeredCodec(dt); ok {
+			return refl
+		}
+	}
+	return func(d *Decoder, v reflect.Value) error {
+		if d.codecs != nil {
+			for _, dt := range direct {
+				if _, ok := d.codecs[dt]; ok {
+					return refl(d, v)
+				}
+			}
+		}
+		return decUnmarshalerEx(d, v)
+	}
+}
+
+// encMarshalerEx writes a value whose pointer implements Marshaler.
+func encMarshalerEx(e *Encoder, v reflect.Value) error {
+	if !v.CanAddr() {
+		pv := reflect.New(v.Type())
+		pv.Elem().Set(v)
+		v = pv.Elem()
+	}
+	return v.Addr().Interface().(Marshaler).MarshalBinaryEx(e)
+}
+
+// decUnmarshalerEx reads a value whose pointer implements Unmarshaler.
+func decUnmarshalerEx(d *Decoder, v reflect.Value) (err error) {
+	if err = d.enter(); err != nil {
+		return
+	}
+	if err = v.Addr().Interface().(Unmarshaler).UnmarshalBinaryEx(d); err == nil {
+		d.leave()
+	}
+	return
+}
+
+// intKind returns the int kind of size bytes or an invalid kind.
+func intKind(size int) reflect.Kind {
+	switch size {
+	case 1:
+		return reflect.Int8
+	case 2:
+		return reflect.Int16
+	case 4:
+		return reflect.Int32
+	case 8:
+		return reflect.Int64
+	}
+	return reflect.Invalid
+}
+
+// uintKind returns the uint kind of size bytes or an invalid kind.
+func uintKind(size int) reflect.Kind {
+	switch size {
+	case 1:
+		return reflect.Uint8
+	case 2:
+		return reflect.Uint16
+	case 4:
+		return reflect.Uint32
+	case 8:
+		return reflect.Uint64
+	}
+	return reflect.Invalid
+}
+
+// WriteBool writes a bool. It is used by generated MarshalBinaryEx methods.
+func (e *Encoder) WriteBool(b bool) error {
+	return e.writeBool(b)
+}
+
+// WriteInt writes an int of size bytes, 1, 2, 4 or 8, using IntEncoding
+// option. It is used by generated MarshalBinaryEx methods.
+func (e *Encoder) WriteInt(x int64, size int) error {
+	k := intKind(size)
+	if k == reflect.Invalid {
+		return ErrInvalidArgument
+	}
+	return e.writeInt(x, k, e.opts.IntEncoding)
+}
+
+// WriteUint writes an uint of size bytes, 1, 2, 4 or 8, using IntEncoding
+// option. It is used by generated MarshalBinaryEx methods.
+func (e *Encoder) WriteUint(x uint64, size int) error {
+	k := uintKind(size)
+	if k == reflect.Invalid {
+		return ErrInvalidArgument
+	}
+	return e.writeUint(x, k, e.opts.IntEncoding)
+}
+
+// WriteFloat32 writes a float32. It is used by generated MarshalBinaryEx
+// methods.
+func (e *Encoder) WriteFloat32(f float32) error {
+	if e.opts.WideFloats {
+		return e.writeFloat(float64(f))
+	}
+	return e.writeFloat32(f)
+}
+
+// WriteFloat64 writes a float64. It is used by generated MarshalBinaryEx
+// methods.
+func (e *Encoder) WriteFloat64(f float64) error {
+	return e.writeFloat(f)
+}
+
+// WriteComplex64 writes a complex64. It is used by generated
+// MarshalBinaryEx methods.
+func (e *Encoder) WriteComplex64(c complex64) error {
+	if e.opts.WideFloats {
+		return e.writeComplex(complex128(c))
+	}
+	return e.writeComplex64(c)
+}
+
+// WriteComplex128 writes a complex128. It is used by generated
+// MarshalBinaryEx methods.
+func (e *Encoder) WriteComplex128(c complex128) error {
+	return e.writeComplex(c)
+}
+
+// WriteString writes a string. It is used by generated MarshalBinaryEx
+// methods.
+func (e *Encoder) WriteString(s string) error {
+	return e.writeString(s)
+}
+
+// WriteBytes writes a byte slice. It is used by generated MarshalBinaryEx
+// methods.
+func (e *Encoder) WriteBytes(p []byte) error {
+	if p == nil && e.opts.NilMode == NilPreserve {
+		return e.writeLen(-1)
+	}
+	return e.writeBytes(p)
+}
+
+// WriteValue writes a value of any type ptr points to as a part of the
+// value being written. It is used by generated MarshalBinaryEx methods for
+// fields they do not write directly.
+func (e *Encoder) WriteValue(ptr interface{}) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return ErrInvalidArgument
+	}
+	v = v.Elem()
+	return encPlanFor(v.Type())(e, v)
+}
+
+// ReadBool reads a bool. It is used by generated UnmarshalBinaryEx methods.
+func (d *Decoder) ReadBool() (bool, error) {
+	return d.readBool()
+}
+
+// ReadInt reads an int of size bytes, 1, 2, 4 or 8, using IntEncoding
+// option. It is used by generated UnmarshalBinaryEx methods.
+func (d *Decoder) ReadInt(size int) (int64, error) {
+	k := intKind(size)
+	if k == reflect.Invalid {
+		return 0, ErrInvalidArgument
+	}
+	return d.readInt(k, d.opts.IntEncoding)
+}
+
+// ReadUint reads an uint of size bytes, 1, 2, 4 or 8, using IntEncoding
+// option. It is used by generated UnmarshalBinaryEx methods.
+func (d *Decoder) ReadUint(size int) (uint64, error) {
+	k := uintKind(size)
+	if k == reflect.Invalid {
+		return 0, ErrInvalidArgument
+	}
+	return d.readUint(k, d.opts.IntEncoding)
+}
+
+// ReadFloat32 reads a float32. It is used by generated UnmarshalBinaryEx
+// methods.
+func (d *Decoder) ReadFloat32() (float32, error) {
+	if d.opts.WideFloats {
+		f, err := d.readFloat()
+		return float32(f), err
+	}
+	return d.readFloat32()
+}
+
+// ReadFloat64 reads a float64. It is used by generated UnmarshalBinaryEx
+// methods.
+func (d *Decoder) ReadFloat64() (float64, error) {
+	return d.readFloat()
+}
+
+// ReadComplex64 reads a complex64. It is used by generated
+// UnmarshalBinaryEx methods.
+func (d *Decoder) ReadComplex64() (complex64, error) {
+	if d.opts.WideFloats {
+		c, err := d.readComplex()
+		return complex64(c), err
+	}
+	return d.readComplex64()
+}
+
+// ReadComplex128 reads a complex128. It is used by generated
+// UnmarshalBinaryEx methods.
+func (d *Decoder) ReadComplex128() (complex128, error) {
+	return d.readComplex()
+}
+
+// ReadString reads a string. It is used by generated UnmarshalBinaryEx
+// methods.
+func (d *Decoder) ReadString() (string, error) {
+	return d.readString()
+}
+
+// ReadBytes reads a byte slice. It is used by generated UnmarshalBinaryEx
+// methods.
+func (d *Decoder) ReadBytes() ([]byte, error) {
+	l, err := d.readNilLen(d.opts.MaxSliceLen)
+	if err != nil || l < 0 {
+		return nil, err
+	}
+	return d.readAlias(l)
+}
+
+// ReadValue reads a value of any type ptr points to as a part of the value
+// being read. It is used by generated UnmarshalBinaryEx methods for fields
+// they do not read directly.
+func (d *Decoder) ReadValue(ptr interface{}) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return ErrInvalidArgument
+	}
+	v = v.Elem()
+	return decPlanFor(v.Type())(d, v)
+}
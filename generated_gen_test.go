@@ -0,0 +1,311 @@
+// Code generated by binaryex-gen. DO NOT EDIT.
+
+package binaryex
+
+// MarshalBinaryEx implements Marshaler.
+func (x *GenStructType) MarshalBinaryEx(e *Encoder) (err error) {
+	if err = e.WriteValue(&x.TimeField); err != nil {
+		return
+	}
+	return
+}
+
+// UnmarshalBinaryEx implements Unmarshaler.
+func (x *GenStructType) UnmarshalBinaryEx(d *Decoder) (err error) {
+	if err = d.ReadValue(&x.TimeField); err != nil {
+		return
+	}
+	return
+}
+
+// MarshalBinaryEx implements Marshaler.
+func (x *GenBaseTypes) MarshalBinaryEx(e *Encoder) (err error) {
+	if err = e.WriteBool(x.BoolField); err != nil {
+		return
+	}
+	if err = e.WriteInt(int64(x.IntField), 8); err != nil {
+		return
+	}
+	if err = e.WriteUint(uint64(x.UintField), 8); err != nil {
+		return
+	}
+	if err = e.WriteInt(int64(x.Int8Field), 1); err != nil {
+		return
+	}
+	if err = e.WriteUint(uint64(x.Uint8Field), 1); err != nil {
+		return
+	}
+	if err = e.WriteInt(int64(x.Int16Field), 2); err != nil {
+		return
+	}
+	if err = e.WriteUint(uint64(x.Uint16Field), 2); err != nil {
+		return
+	}
+	if err = e.WriteInt(int64(x.Int32Field), 4); err != nil {
+		return
+	}
+	if err = e.WriteUint(uint64(x.Uint32Field), 4); err != nil {
+		return
+	}
+	if err = e.WriteInt(int64(x.Int64Field), 8); err != nil {
+		return
+	}
+	if err = e.WriteUint(uint64(x.Uint64Field), 8); err != nil {
+		return
+	}
+	if err = e.WriteFloat32(x.Float32Field); err != nil {
+		return
+	}
+	if err = e.WriteFloat64(x.Float64Field); err != nil {
+		return
+	}
+	if err = e.WriteComplex64(x.Complex64Field); err != nil {
+		return
+	}
+	if err = e.WriteComplex128(x.Complex128Field); err != nil {
+		return
+	}
+	if err = e.WriteString(x.StringField); err != nil {
+		return
+	}
+	if err = e.WriteValue(&x.ArrayField); err != nil {
+		return
+	}
+	if err = e.WriteValue(&x.SliceField); err != nil {
+		return
+	}
+	if err = e.WriteValue(&x.MapField); err != nil {
+		return
+	}
+	return
+}
+
+// UnmarshalBinaryEx implements Unmarshaler.
+func (x *GenBaseTypes) UnmarshalBinaryEx(d *Decoder) (err error) {
+	if x.BoolField, err = d.ReadBool(); err != nil {
+		return
+	}
+	{
+		var v int64
+		if v, err = d.ReadInt(8); err != nil {
+			return
+		}
+		x.IntField = int(v)
+	}
+	{
+		var v uint64
+		if v, err = d.ReadUint(8); err != nil {
+			return
+		}
+		x.UintField = uint(v)
+	}
+	{
+		var v int64
+		if v, err = d.ReadInt(1); err != nil {
+			return
+		}
+		x.Int8Field = int8(v)
+	}
+	{
+		var v uint64
+		if v, err = d.ReadUint(1); err != nil {
+			return
+		}
+		x.Uint8Field = uint8(v)
+	}
+	{
+		var v int64
+		if v, err = d.ReadInt(2); err != nil {
+			return
+		}
+		x.Int16Field = int16(v)
+	}
+	{
+		var v uint64
+		if v, err = d.ReadUint(2); err != nil {
+			return
+		}
+		x.Uint16Field = uint16(v)
+	}
+	{
+		var v int64
+		if v, err = d.ReadInt(4); err != nil {
+			return
+		}
+		x.Int32Field = int32(v)
+	}
+	{
+		var v uint64
+		if v, err = d.ReadUint(4); err != nil {
+			return
+		}
+		x.Uint32Field = uint32(v)
+	}
+	if x.Int64Field, err = d.ReadInt(8); err != nil {
+		return
+	}
+	if x.Uint64Field, err = d.ReadUint(8); err != nil {
+		return
+	}
+	if x.Float32Field, err = d.ReadFloat32(); err != nil {
+		return
+	}
+	if x.Float64Field, err = d.ReadFloat64(); err != nil {
+		return
+	}
+	if x.Complex64Field, err = d.ReadComplex64(); err != nil {
+		return
+	}
+	if x.Complex128Field, err = d.ReadComplex128(); err != nil {
+		return
+	}
+	if x.StringField, err = d.ReadString(); err != nil {
+		return
+	}
+	if err = d.ReadValue(&x.ArrayField); err != nil {
+		return
+	}
+	if err = d.ReadValue(&x.SliceField); err != nil {
+		return
+	}
+	if err = d.ReadValue(&x.MapField); err != nil {
+		return
+	}
+	return
+}
+
+// MarshalBinaryEx implements Marshaler.
+func (x *GenMarshalableTypes) MarshalBinaryEx(e *Encoder) (err error) {
+	if err = e.WriteValue(&x.TimeField); err != nil {
+		return
+	}
+	return
+}
+
+// UnmarshalBinaryEx implements Unmarshaler.
+func (x *GenMarshalableTypes) UnmarshalBinaryEx(d *Decoder) (err error) {
+	if err = d.ReadValue(&x.TimeField); err != nil {
+		return
+	}
+	return
+}
+
+// MarshalBinaryEx implements Marshaler.
+func (x *GenPointerTypes) MarshalBinaryEx(e *Encoder) (err error) {
+	if err = e.WriteValue(&x.PBoolField); err != nil {
+		return
+	}
+	if err = e.WriteValue(&x.PStringField); err != nil {
+		return
+	}
+	if err = e.WriteValue(&x.PStructField); err != nil {
+		return
+	}
+	return
+}
+
+// UnmarshalBinaryEx implements Unmarshaler.
+func (x *GenPointerTypes) UnmarshalBinaryEx(d *Decoder) (err error) {
+	if err = d.ReadValue(&x.PBoolField); err != nil {
+		return
+	}
+	if err = d.ReadValue(&x.PStringField); err != nil {
+		return
+	}
+	if err = d.ReadValue(&x.PStructField); err != nil {
+		return
+	}
+	return
+}
+
+// MarshalBinaryEx implements Marshaler.
+func (x *GenDeepPointerTypes) MarshalBinaryEx(e *Encoder) (err error) {
+	if err = e.WriteValue(&x.PPointerField); err != nil {
+		return
+	}
+	return
+}
+
+// UnmarshalBinaryEx implements Unmarshaler.
+func (x *GenDeepPointerTypes) UnmarshalBinaryEx(d *Decoder) (err error) {
+	if err = d.ReadValue(&x.PPointerField); err != nil {
+		return
+	}
+	return
+}
+
+// MarshalBinaryEx implements Marshaler.
+func (x *GenNilTypes) MarshalBinaryEx(e *Encoder) (err error) {
+	if err = e.WriteValue(&x.PBoolField); err != nil {
+		return
+	}
+	if err = e.WriteValue(&x.PStringField); err != nil {
+		return
+	}
+	if err = e.WriteValue(&x.PMapField); err != nil {
+		return
+	}
+	return
+}
+
+// UnmarshalBinaryEx implements Unmarshaler.
+func (x *GenNilTypes) UnmarshalBinaryEx(d *Decoder) (err error) {
+	if err = d.ReadValue(&x.PBoolField); err != nil {
+		return
+	}
+	if err = d.ReadValue(&x.PStringField); err != nil {
+		return
+	}
+	if err = d.ReadValue(&x.PMapField); err != nil {
+		return
+	}
+	return
+}
+
+// MarshalBinaryEx implements Marshaler.
+func (x *GenAllTypes) MarshalBinaryEx(e *Encoder) (err error) {
+	if err = e.WriteValue(&x.GenBaseTypes); err != nil {
+		return
+	}
+	if err = e.WriteValue(&x.GenMarshalableTypes); err != nil {
+		return
+	}
+	return
+}
+
+// UnmarshalBinaryEx implements Unmarshaler.
+func (x *GenAllTypes) UnmarshalBinaryEx(d *Decoder) (err error) {
+	if err = d.ReadValue(&x.GenBaseTypes); err != nil {
+		return
+	}
+	if err = d.ReadValue(&x.GenMarshalableTypes); err != nil {
+		return
+	}
+	return
+}
+
+// MarshalBinaryEx implements Marshaler.
+func (x *GenCodecTypes) MarshalBinaryEx(e *Encoder) (err error) {
+	if err = e.WriteInt(int64(x.Level), 1); err != nil {
+		return
+	}
+	if err = e.WriteString(x.Name); err != nil {
+		return
+	}
+	return
+}
+
+// UnmarshalBinaryEx implements Unmarshaler.
+func (x *GenCodecTypes) UnmarshalBinaryEx(d *Decoder) (err error) {
+	{
+		var v int64
+		if v, err = d.ReadInt(1); err != nil {
+			return
+		}
+		x.Level = GenLevel(v)
+	}
+	if x.Name, err = d.ReadString(); err != nil {
+		return
+	}
+	return
+}
@@ -0,0 +1,289 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+//go:generate go run ./cmd/binaryex-gen -type GenStructType,GenBaseTypes,GenMarshalableTypes,GenPointerTypes,GenDeepPointerTypes,GenNilTypes,GenAllTypes,GenCodecTypes -output generated_gen_test.go generated_test.go
+
+// Types with generated methods mirror types in binaryex_test.go, which are
+// written by reflection.
+
+type GenStructType struct {
+	TimeField time.Time
+}
+
+type GenBaseTypes struct {
+	BoolField       bool
+	IntField        int
+	UintField       uint
+	Int8Field       int8
+	Uint8Field      uint8
+	Int16Field      int16
+	Uint16Field     uint16
+	Int32Field      int32
+	Uint32Field     uint32
+	Int64Field      int64
+	Uint64Field     uint64
+	Float32Field    float32
+	Float64Field    float64
+	Complex64Field  complex64
+	Complex128Field complex128
+	StringField     string
+	ArrayField      [5]byte
+	SliceField      []string
+	MapField        map[string]int
+}
+
+func (tt *GenBaseTypes) init() {
+	var base BaseTypes
+	base.init()
+	*tt = GenBaseTypes(base)
+}
+
+type GenMarshalableTypes struct {
+	TimeField time.Time
+}
+
+type GenPointerTypes struct {
+	PBoolField   *bool
+	PStringField *string
+	PStructField *GenBaseTypes
+}
+
+func (pt *GenPointerTypes) init() {
+	bv := true
+	pt.PBoolField = &bv
+	sv := "teststring"
+	pt.PStringField = &sv
+	pt.PStructField = &GenBaseTypes{}
+	pt.PStructField.init()
+}
+
+type GenDeepPointerTypes struct {
+	PPointerField ***bool
+}
+
+func (dpt *GenDeepPointerTypes) init() {
+	pv0 := true
+	pv1 := &pv0
+	pv2 := &pv1
+	dpt.PPointerField = &pv2
+}
+
+type GenNilTypes struct {
+	PBoolField   *bool
+	PStringField *string
+	PMapField    *map[string]string
+}
+
+type GenAllTypes struct {
+	GenBaseTypes
+	GenMarshalableTypes
+}
+
+func (at *GenAllTypes) init() {
+	at.GenBaseTypes.init()
+	at.GenMarshalableTypes.TimeField = time.Unix(1e9, 1).UTC()
+}
+
+// GenLevel has a codec registered which generated methods do not use.
+type GenLevel int8
+
+func init() {
+	RegisterCodec(reflect.TypeOf(GenLevel(0)),
+		func(e *Encoder, v reflect.Value) error {
+			return e.WriteString(strconv.Itoa(int(v.Int())))
+		},
+		func(d *Decoder, v reflect.Value) error {
+			s, err := d.ReadString()
+			if err != nil {
+				return err
+			}
+			n, err := strconv.Atoi(s)
+			v.SetInt(int64(n))
+			return err
+		})
+}
+
+type GenCodecTypes struct {
+	Level GenLevel
+	Name  string
+}
+
+// generatedValues returns pointers to initialized values of types with
+// methods in generated_gen_test.go.
+func generatedValues() []interface{} {
+	base := &GenBaseTypes{}
+	base.init()
+	ptrs := &GenPointerTypes{}
+	ptrs.init()
+	deep := &GenDeepPointerTypes{}
+	deep.init()
+	str := "nil"
+	all := &GenAllTypes{}
+	all.init()
+	return []interface{}{
+		&GenStructType{time.Unix(1e9, 0).UTC()},
+		base,
+		&GenMarshalableTypes{time.Unix(2e9, 0).UTC()},
+		ptrs,
+		deep,
+		&GenNilTypes{},
+		&GenNilTypes{PStringField: &str},
+		all,
+	}
+}
+
+func TestGenerated(t *testing.T) {
+	for _, opts := range []EncoderOptions{
+		{},
+		{NilMode: NilPreserve},
+		{IntEncoding: IntFixed},
+		{IntEncoding: IntVarint},
+		{WideFloats: true},
+		{Packed: true},
+		{References: true},
+	} {
+		opts.Canonical = true
+		dopts := DecoderOptions{
+			NilMode:     opts.NilMode,
+			WideFloats:  opts.WideFloats,
+			Packed:      opts.Packed,
+			IntEncoding: opts.IntEncoding,
+			References:  opts.References,
+		}
+		for _, val := range generatedValues() {
+			v := reflect.ValueOf(val).Elem()
+			if _, ok := val.(Marshaler); !ok {
+				t.Fatalf("%T: generated methods missing", val)
+			}
+			refl := bytes.NewBuffer(nil)
+			enc := NewEncoderWithOptions(refl, opts)
+			if err := newReflectEncFunc(v.Type())(enc, v); err != nil {
+				t.Fatal("reflective encode failed", err)
+			}
+			gen := bytes.NewBuffer(nil)
+			enc = NewEncoderWithOptions(gen, opts)
+			if err := val.(Marshaler).MarshalBinaryEx(enc); err != nil {
+				t.Fatal("MarshalBinaryEx failed", err)
+			}
+			if !bytes.Equal(refl.Bytes(), gen.Bytes()) {
+				t.Fatalf("%T %+v: generated encoding differs:\n% x\n% x", val, opts, refl.Bytes(), gen.Bytes())
+			}
+			in := reflect.New(v.Type())
+			dec := NewSliceDecoder(refl.Bytes(), dopts)
+			if err := newReflectDecFunc(v.Type())(dec, in.Elem()); err != nil {
+				t.Fatal("reflective decode failed", err)
+			}
+			genIn := reflect.New(v.Type())
+			dec = NewSliceDecoder(gen.Bytes(), dopts)
+			if err := genIn.Interface().(Unmarshaler).UnmarshalBinaryEx(dec); err != nil {
+				t.Fatal("UnmarshalBinaryEx failed", err)
+			}
+			if !reflect.DeepEqual(in.Interface(), genIn.Interface()) {
+				t.Fatalf("%T %+v: generated decoding differs:\n%v\n%v", val, opts, in, genIn)
+			}
+		}
+	}
+}
+
+func TestGeneratedSelfDescribing(t *testing.T) {
+	for _, val := range generatedValues() {
+		buf := bytes.NewBuffer(nil)
+		enc := NewEncoderWithOptions(buf, EncoderOptions{SelfDescribing: true, NilMode: NilPreserve})
+		if err := enc.Encode(val); err != nil {
+			t.Fatal("Encode failed", err)
+		}
+		in := reflect.New(reflect.TypeOf(val).Elem())
+		dec := NewDecoderWithOptions(buf, DecoderOptions{SelfDescribing: true, NilMode: NilPreserve})
+		if err := dec.Decode(in.Interface()); err != nil {
+			t.Fatal("Decode failed", err)
+		}
+		if !reflect.DeepEqual(in.Interface(), val) {
+			t.Fatalf("Encode/Decode missmatch: in\n%v, out:\n%v\n", in, val)
+		}
+	}
+}
+
+func TestGeneratedPromoted(t *testing.T) {
+	if hasGenerated(reflect.TypeOf(struct{ GenBaseTypes }{}), marshalerType) {
+		t.Fatal("methods promoted from an embedded Marshaler used")
+	}
+	if !hasGenerated(reflect.TypeOf(GenAllTypes{}), marshalerType) {
+		t.Fatal("generated methods of a struct embedding a Marshaler not used")
+	}
+	if !hasGenerated(reflect.TypeOf(GenBaseTypes{}), marshalerType) {
+		t.Fatal("generated methods not used")
+	}
+}
+
+func TestGeneratedCodecs(t *testing.T) {
+	// A global codec for a field type.
+	out := GenCodecTypes{3, "name"}
+	data, err := Marshal(out)
+	if err != nil {
+		t.Fatal("Marshal failed", err)
+	}
+	if !bytes.Equal(data, []byte{2, '3', 8, 'n', 'a', 'm', 'e'}) {
+		t.Fatalf("codec not used: % x", data)
+	}
+	var in GenCodecTypes
+	if err := Unmarshal(data, &in); err != nil {
+		t.Fatal("Unmarshal failed", err)
+	}
+	if in != out {
+		t.Fatalf("Marshal/Unmarshal missmatch: %v", in)
+	}
+
+	// An Encoder and Decoder codec for a field type, compared to the same
+	// type without generated methods.
+	stringType := reflect.TypeOf("")
+	quote := func(e *Encoder, v reflect.Value) error {
+		return e.WriteBytes([]byte(strconv.Quote(v.String())))
+	}
+	unquote := func(d *Decoder, v reflect.Value) error {
+		p, err := d.ReadBytes()
+		if err != nil {
+			return err
+		}
+		s, err := strconv.Unquote(string(p))
+		v.SetString(s)
+		return err
+	}
+	var base BaseTypes
+	base.init()
+	base.MapField = map[string]int{"one": 1}
+	refl := bytes.NewBuffer(nil)
+	enc := NewEncoder(refl)
+	enc.RegisterCodec(stringType, quote)
+	if err := enc.Encode(base); err != nil {
+		t.Fatal("Encode failed", err)
+	}
+	gen := bytes.NewBuffer(nil)
+	enc = NewEncoder(gen)
+	enc.RegisterCodec(stringType, quote)
+	if err := enc.Encode(GenBaseTypes(base)); err != nil {
+		t.Fatal("Encode failed", err)
+	}
+	if !bytes.Equal(refl.Bytes(), gen.Bytes()) {
+		t.Fatalf("codec not used:\n% x\n% x", refl.Bytes(), gen.Bytes())
+	}
+	var genIn GenBaseTypes
+	dec := NewDecoder(gen)
+	dec.RegisterCodec(stringType, unquote)
+	if err := dec.Decode(&genIn); err != nil {
+		t.Fatal("Decode failed", err)
+	}
+	if !reflect.DeepEqual(BaseTypes(genIn), base) {
+		t.Fatalf("Encode/Decode missmatch: %v", genIn)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"io"
+	"reflect"
+)
+
+// Encode returns the encoding of value v of type T or an error if one
+// occured.
+//
+// Unlike Marshal, v is written as a value of type T; if T is a pointer type
+// the pointer is written and if T is an interface type v is written as an
+// interface value whose concrete type must be registered using Register.
+func Encode[T any](v T) ([]byte, error) {
+	return AppendEncode(nil, v)
+}
+
+// AppendEncode appends the encoding of value v of type T to dst and returns
+// the extended slice or returns dst and an error if one occured. Values are
+// encoded as by Encode.
+func AppendEncode[T any](dst []byte, v T) ([]byte, error) {
+	sw := &sliceWriter{dst}
+	e := getEncoder(sw)
+	defer putEncoder(e)
+	if err := e.EncodeValue(reflect.ValueOf(&v).Elem()); err != nil {
+		return dst, err
+	}
+	return sw.p, nil
+}
+
+// Decode reads a value of type T from data written by Encode and returns it
+// or returns an error if one occured. Data after the value is ignored.
+func Decode[T any](data []byte) (v T, err error) {
+	d := getSliceDecoder(data)
+	defer putDecoder(d)
+	err = d.DecodeValue(reflect.ValueOf(&v).Elem())
+	return
+}
+
+// Codec encodes and decodes values of type T using encoding plans compiled
+// when the Codec is created. Values are encoded as by Encode. A Codec is
+// safe for concurrent use.
+type Codec[T any] struct {
+	typ  reflect.Type
+	enc  encFunc
+	dec  decFunc
+	eopt EncoderOptions
+	dopt DecoderOptions
+}
+
+// NewCodec returns a new Codec for values of type T that uses
+// DefaultEncoderOptions and DefaultDecoderOptions.
+func NewCodec[T any]() *Codec[T] {
+	return NewCodecWithOptions[T](DefaultEncoderOptions, DefaultDecoderOptions)
+}
+
+// NewCodecWithOptions returns a new Codec for values of type T that
+// encodes using eopts and decodes using dopts.
+func NewCodecWithOptions[T any](eopts EncoderOptions, dopts DecoderOptions) *Codec[T] {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	return &Codec[T]{
+		typ:  t,
+		enc:  encPlanFor(t),
+		dec:  decPlanFor(t),
+		eopt: eopts,
+		dopt: dopts,
+	}
+}
+
+// Marshal returns the encoding of value v or an error if one occured.
+func (c *Codec[T]) Marshal(v T) ([]byte, error) {
+	return c.AppendMarshal(nil, v)
+}
+
+// AppendMarshal appends the encoding of value v to dst and returns the
+// extended slice or returns dst and an error if one occured.
+func (c *Codec[T]) AppendMarshal(dst []byte, v T) ([]byte, error) {
+	sw := &sliceWriter{dst}
+	e := getEncoder(sw)
+	defer putEncoder(e)
+	e.opts = c.eopt
+	if err := c.Encode(e, v); err != nil {
+		return dst, err
+	}
+	return sw.p, nil
+}
+
+// Unmarshal reads a value from data and returns it or returns an error if
+// one occured. Data after the value is ignored.
+func (c *Codec[T]) Unmarshal(data []byte) (T, error) {
+	d := getSliceDecoder(data)
+	defer putDecoder(d)
+	d.opts = c.dopt
+	return c.Decode(d)
+}
+
+// Write writes value v to writer w or returns an error if one occured.
+func (c *Codec[T]) Write(w io.Writer, v T) error {
+	e := getEncoder(w)
+	defer putEncoder(e)
+	e.opts = c.eopt
+	return c.Encode(e, v)
+}
+
+// Read reads a value from reader r and returns it or returns an error if
+// one occured.
+func (c *Codec[T]) Read(r io.Reader) (T, error) {
+	d := getDecoder(r)
+	defer putDecoder(d)
+	d.opts = c.dopt
+	return c.Decode(d)
+}
+
+// Encode writes value v to the stream of Encoder e using options of e or
+// returns an error if one occured.
+func (c *Codec[T]) Encode(e *Encoder, v T) error {
+	return e.encodeError(e.encodeValue(reflect.ValueOf(&v).Elem(), c.enc), c.typ)
+}
+
+// Decode reads the next value from the stream of Decoder d using options
+// of d and returns it or returns an error if one occured.
+func (c *Codec[T]) Decode(d *Decoder) (v T, err error) {
+	err = d.decodeValue(reflect.ValueOf(&v).Elem(), c.dec)
+	return
+}
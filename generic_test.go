@@ -0,0 +1,149 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	out := BaseTypes{}
+	out.init()
+	out.MapField = map[string]int{"one": 1}
+	data, err := Encode(out)
+	if err != nil {
+		t.Fatal("Encode failed", err)
+	}
+	marshaled, err := Marshal(out)
+	if err != nil {
+		t.Fatal("Marshal failed", err)
+	}
+	if !bytes.Equal(data, marshaled) {
+		t.Fatalf("Encode and Marshal differ:\n% x\n% x", data, marshaled)
+	}
+	in, err := Decode[BaseTypes](data)
+	if err != nil {
+		t.Fatal("Decode failed", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("Encode/Decode missmatch: in\n%v, out:\n%v\n", in, out)
+	}
+
+	var shape Shape = Rect{2, 3}
+	if data, err = Encode(shape); err != nil {
+		t.Fatal("Encode failed", err)
+	}
+	inShape, err := Decode[Shape](data)
+	if err != nil {
+		t.Fatal("Decode failed", err)
+	}
+	if inShape != shape {
+		t.Fatalf("Encode/Decode missmatch: %v", inShape)
+	}
+
+	if _, err := Decode[BaseTypes](data[:0]); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	var de *DecodeError
+	if _, err := Decode[BaseTypes](marshaled[:len(marshaled)-1]); !errors.As(err, &de) {
+		t.Fatalf("expected DecodeError, got %v", err)
+	}
+}
+
+func TestEncodeDecodePointer(t *testing.T) {
+	DefaultEncoderOptions.NilMode = NilPreserve
+	DefaultDecoderOptions.NilMode = NilPreserve
+	defer func() {
+		DefaultEncoderOptions.NilMode = NilLegacy
+		DefaultDecoderOptions.NilMode = NilLegacy
+	}()
+	for _, out := range []*PointerTypes{nil, {}} {
+		data, err := Encode(out)
+		if err != nil {
+			t.Fatal("Encode failed", err)
+		}
+		in, err := Decode[*PointerTypes](data)
+		if err != nil {
+			t.Fatal("Decode failed", err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("Encode/Decode missmatch: in\n%v, out:\n%v\n", in, out)
+		}
+	}
+}
+
+func TestCodecGeneric(t *testing.T) {
+	c := NewCodecWithOptions[AllTypes](
+		EncoderOptions{SelfDescribing: true, Canonical: true},
+		DecoderOptions{SelfDescribing: true})
+	out := AllTypes{}
+	out.init()
+	out.TimeField = time.Unix(1e9, 0).UTC()
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := c.Marshal(out)
+			if err != nil {
+				errs <- err
+				return
+			}
+			in, err := c.Unmarshal(data)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !reflect.DeepEqual(in, out) {
+				errs <- errors.New("Marshal/Unmarshal missmatch")
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := c.Write(buf, out); err != nil {
+		t.Fatal("Write failed", err)
+	}
+	in, err := c.Read(buf)
+	if err != nil {
+		t.Fatal("Read failed", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("Write/Read missmatch: in\n%v, out:\n%v\n", in, out)
+	}
+
+	ints := NewCodec[[]int]()
+	enc := NewEncoderWithOptions(buf, EncoderOptions{IntEncoding: IntFixed})
+	for i := 0; i < 3; i++ {
+		if err := ints.Encode(enc, []int{i, -i}); err != nil {
+			t.Fatal("Encode failed", err)
+		}
+	}
+	dec := NewDecoderWithOptions(buf, DecoderOptions{IntEncoding: IntFixed})
+	for i := 0; i < 3; i++ {
+		v, err := ints.Decode(dec)
+		if err != nil {
+			t.Fatal("Decode failed", err)
+		}
+		if !reflect.DeepEqual(v, []int{i, -i}) {
+			t.Fatalf("Encode/Decode missmatch: %v", v)
+		}
+	}
+	if _, err := ints.Decode(dec); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
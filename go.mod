@@ -1,5 +1,5 @@
 module binaryex
 
-go 1.14
+go 1.18
 
 require github.com/vedranvuk/errorex v0.3.1
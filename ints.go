@@ -0,0 +1,152 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"encoding/binary"
+	"reflect"
+)
+
+// isIntType returns true if t is an int or an uint type that is encoded
+// according to IntEncoding.
+func isIntType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		return !hasMarshaler(t)
+	}
+	return false
+}
+
+// isIntSequence returns true if t is an array or a slice of ints or uints.
+func isIntSequence(t reflect.Type) bool {
+	return (t.Kind() == reflect.Array || t.Kind() == reflect.Slice) &&
+		isIntType(t.Elem())
+}
+
+// byteOrder returns the byte order of fixed width encoding enc.
+func byteOrder(enc IntEncoding) binary.ByteOrder {
+	if enc == IntFixedBE {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// writeFixed writes the low size bytes of x in byte order of enc.
+func (e *Encoder) writeFixed(x uint64, size int, enc IntEncoding) error {
+	bo := byteOrder(enc)
+	switch size {
+	case 1:
+		e.buf[0] = byte(x)
+	case 2:
+		bo.PutUint16(e.buf[:], uint16(x))
+	case 4:
+		bo.PutUint32(e.buf[:], uint32(x))
+	default:
+		bo.PutUint64(e.buf[:], x)
+	}
+	return e.write(e.buf[:size])
+}
+
+// writeInt writes an int x of kind k using encoding enc.
+func (e *Encoder) writeInt(x int64, k reflect.Kind, enc IntEncoding) error {
+	switch enc {
+	case IntVarint:
+		return e.writeUvarint(uint64(x))
+	case IntFixed, IntFixedBE:
+		return e.writeFixed(uint64(x), fixedSize(k), enc)
+	}
+	return e.writeVarint(x)
+}
+
+// writeUint writes an uint x of kind k using encoding enc.
+func (e *Encoder) writeUint(x uint64, k reflect.Kind, enc IntEncoding) error {
+	switch enc {
+	case IntFixed, IntFixedBE:
+		return e.writeFixed(x, fixedSize(k), enc)
+	}
+	return e.writeUvarint(x)
+}
+
+// readFixed reads a size bytes long number in byte order of enc.
+func (d *Decoder) readFixed(size int, enc IntEncoding) (uint64, error) {
+	if err := d.readFull(d.buf[:size]); err != nil {
+		return 0, err
+	}
+	bo := byteOrder(enc)
+	switch size {
+	case 1:
+		return uint64(d.buf[0]), nil
+	case 2:
+		return uint64(bo.Uint16(d.buf[:])), nil
+	case 4:
+		return uint64(bo.Uint32(d.buf[:])), nil
+	}
+	return bo.Uint64(d.buf[:]), nil
+}
+
+// readInt reads an int of kind k written using encoding enc.
+func (d *Decoder) readInt(k reflect.Kind, enc IntEncoding) (int64, error) {
+	switch enc {
+	case IntVarint:
+		x, err := d.readUvarint()
+		return int64(x), err
+	case IntFixed, IntFixedBE:
+		size := fixedSize(k)
+		x, err := d.readFixed(size, enc)
+		// Sign extend.
+		shift := uint(64 - 8*size)
+		return int64(x<<shift) >> shift, err
+	}
+	return d.readVarint()
+}
+
+// readUint reads an uint of kind k written using encoding enc.
+func (d *Decoder) readUint(k reflect.Kind, enc IntEncoding) (uint64, error) {
+	switch enc {
+	case IntFixed, IntFixedBE:
+		return d.readFixed(fixedSize(k), enc)
+	}
+	return d.readUvarint()
+}
+
+// newIntEncoder returns a plan that writes an int or an uint type t using
+// encoding enc regardless of IntEncoding option.
+func newIntEncoder(t reflect.Type, enc IntEncoding) encFunc {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(e *Encoder, v reflect.Value) error {
+			return e.writeInt(v.Int(), v.Kind(), enc)
+		}
+	}
+	return func(e *Encoder, v reflect.Value) error {
+		return e.writeUint(v.Uint(), v.Kind(), enc)
+	}
+}
+
+// newIntDecoder returns a plan that reads an int or an uint type t written
+// using encoding enc regardless of IntEncoding option.
+func newIntDecoder(t reflect.Type, enc IntEncoding) decFunc {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(d *Decoder, v reflect.Value) error {
+			x, err := d.readInt(v.Kind(), enc)
+			if err != nil {
+				return err
+			}
+			v.SetInt(x)
+			return nil
+		}
+	}
+	return func(d *Decoder, v reflect.Value) error {
+		x, err := d.readUint(v.Kind(), enc)
+		if err != nil {
+			return err
+		}
+		v.SetUint(x)
+		return nil
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type IntTypes struct {
+	Hash  uint64   `binaryex:",fixed"`
+	ID    int64    `binaryex:",fixedbe"`
+	Neg   int32    `binaryex:",varint"`
+	Small int16    `binaryex:",zigzag"`
+	IDs   []uint32 `binaryex:",fixedbe"`
+	Arr   [2]int8  `binaryex:",fixed"`
+	Byte  uint8    `binaryex:",varint"`
+	Bytes []byte   `binaryex:",fixed"`
+	Int   int
+	Uints []uint
+}
+
+func TestIntEncodingBytes(t *testing.T) {
+	for _, test := range []struct {
+		val  interface{}
+		opts EncoderOptions
+		data []byte
+	}{
+		{struct {
+			X uint32 `binaryex:",fixedbe"`
+		}{0x01020304}, EncoderOptions{}, []byte{1, 2, 3, 4}},
+		{struct {
+			X int16 `binaryex:",fixed"`
+		}{-2}, EncoderOptions{}, []byte{0xfe, 0xff}},
+		{struct {
+			X int8 `binaryex:",varint"`
+		}{-1}, EncoderOptions{}, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{int8(-1), EncoderOptions{}, []byte{0x01}},
+		{uint(1), EncoderOptions{IntEncoding: IntFixed}, []byte{1, 0, 0, 0, 0, 0, 0, 0}},
+		{[]int16{1, -1}, EncoderOptions{IntEncoding: IntFixedBE}, []byte{4, 0, 1, 0xff, 0xff}},
+		{struct {
+			X int16 `binaryex:",zigzag"`
+		}{-1}, EncoderOptions{IntEncoding: IntFixed}, []byte{0x01}},
+	} {
+		buf := bytes.NewBuffer(nil)
+		enc := NewEncoderWithOptions(buf, test.opts)
+		if err := enc.Encode(test.val); err != nil {
+			t.Fatal("Encode failed", err)
+		}
+		if !bytes.Equal(buf.Bytes(), test.data) {
+			t.Fatalf("%#v: expected % x, got % x", test.val, test.data, buf.Bytes())
+		}
+		in := reflect.New(reflect.TypeOf(test.val))
+		dec := NewDecoderWithOptions(buf, DecoderOptions{IntEncoding: test.opts.IntEncoding})
+		if err := dec.Decode(in.Interface()); err != nil {
+			t.Fatal("Decode failed", err)
+		}
+		if !reflect.DeepEqual(in.Elem().Interface(), test.val) {
+			t.Fatalf("Encode/Decode missmatch: %v, %v", in.Elem(), test.val)
+		}
+	}
+}
+
+func TestIntEncoding(t *testing.T) {
+	out := IntTypes{
+		Hash:  0xdeadbeefcafebabe,
+		ID:    -1 << 40,
+		Neg:   -5,
+		Small: -300,
+		IDs:   []uint32{1, 1 << 31},
+		Arr:   [2]int8{-128, 127},
+		Byte:  255,
+		Bytes: []byte{1, 2},
+		Int:   -1 << 50,
+		Uints: []uint{0, 1 << 63},
+	}
+	base := BaseTypes{}
+	base.init()
+	for _, ie := range []IntEncoding{IntZigZag, IntVarint, IntFixed, IntFixedBE} {
+		for _, sd := range []bool{false, true} {
+			buf := bytes.NewBuffer(nil)
+			enc := NewEncoderWithOptions(buf, EncoderOptions{IntEncoding: ie, SelfDescribing: sd})
+			if err := enc.Encode(out); err != nil {
+				t.Fatal("Encode failed", err)
+			}
+			if err := enc.Encode(base); err != nil {
+				t.Fatal("Encode failed", err)
+			}
+			dec := NewDecoderWithOptions(buf, DecoderOptions{IntEncoding: ie, SelfDescribing: sd})
+			var in IntTypes
+			if err := dec.Decode(&in); err != nil {
+				t.Fatal("Decode failed", err)
+			}
+			if !reflect.DeepEqual(in, out) {
+				t.Fatalf("%d, %t: Encode/Decode missmatch: in\n%v, out:\n%v\n", ie, sd, in, out)
+			}
+			var inBase BaseTypes
+			if err := dec.Decode(&inBase); err != nil {
+				t.Fatal("Decode failed", err)
+			}
+			if !reflect.DeepEqual(inBase, base) {
+				t.Fatalf("%d, %t: Encode/Decode missmatch: in\n%v, out:\n%v\n", ie, sd, inBase, base)
+			}
+		}
+	}
+}
+
+func TestIntEncodingSelfDescribing(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoderWithOptions(buf, EncoderOptions{IntEncoding: IntFixedBE, SelfDescribing: true})
+	if err := enc.Encode(IntTypes{ID: -7, IDs: []uint32{8}, Neg: -9}); err != nil {
+		t.Fatal("Encode failed", err)
+	}
+	var in struct {
+		ID  int8
+		IDs []uint64
+		Neg int
+	}
+	dec := NewDecoderWithOptions(buf, DecoderOptions{SelfDescribing: true})
+	if err := dec.Decode(&in); err != nil {
+		t.Fatal("Decode failed", err)
+	}
+	if in.ID != -7 || !reflect.DeepEqual(in.IDs, []uint64{8}) || in.Neg != -9 {
+		t.Fatalf("Encode/Decode missmatch: %v", in)
+	}
+}
+
+func TestIntEncodingInvalidTag(t *testing.T) {
+	for _, val := range []interface{}{
+		struct {
+			S string `binaryex:",fixed"`
+		}{},
+		struct {
+			F []float64 `binaryex:",varint"`
+		}{},
+		struct {
+			I int `binaryex:",fixed,fixedbe"`
+		}{},
+		struct {
+			I []int `binaryex:",packed,fixed"`
+		}{},
+	} {
+		if err := Write(bytes.NewBuffer(nil), val); !errors.Is(err, ErrInvalidTag) {
+			t.Fatalf("%T: expected ErrInvalidTag, got %v", val, err)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"encoding"
+	"encoding/gob"
+	"reflect"
+	"runtime"
+	"sync"
+)
+
+// MarshalerKind identifies a pair of interfaces through which values of
+// types that implement them encode and decode themselves.
+type MarshalerKind int
+
+const (
+	// BinaryExMarshaler selects Marshaler and Unmarshaler, methods generated
+	// by binaryex-gen. Values are written as if they had no methods.
+	BinaryExMarshaler MarshalerKind = iota + 1
+	// BinaryMarshaler selects encoding.BinaryMarshaler and
+	// encoding.BinaryUnmarshaler. Values are written as byte slices. A type
+	// that implements only one of them uses it in that direction, unless it
+	// implements both methods of another kind.
+	BinaryMarshaler
+	// GobMarshaler selects gob.GobEncoder and gob.GobDecoder. Values are
+	// written as byte slices. It is used only by types that implement both.
+	GobMarshaler
+	// TextMarshaler selects encoding.TextMarshaler and
+	// encoding.TextUnmarshaler. Values are written as byte slices. It is
+	// used only by types that implement both.
+	TextMarshaler
+
+	numMarshalerKinds
+)
+
+// defaultMarshalers is the order of precedence of marshalers if Marshalers
+// option is nil.
+var defaultMarshalers = []MarshalerKind{
+	BinaryExMarshaler, BinaryMarshaler, GobMarshaler, TextMarshaler,
+}
+
+var (
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	gobEncoderType        = reflect.TypeOf((*gob.GobEncoder)(nil)).Elem()
+	gobDecoderType        = reflect.TypeOf((*gob.GobDecoder)(nil)).Elem()
+	textMarshalerType     = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// marshalerTypes holds encoding and decoding interfaces by MarshalerKind.
+var marshalerTypes = [numMarshalerKinds][2]reflect.Type{
+	BinaryExMarshaler: {marshalerType, unmarshalerType},
+	BinaryMarshaler:   {binaryMarshalerType, binaryUnmarshalerType},
+	GobMarshaler:      {gobEncoderType, gobDecoderType},
+	TextMarshaler:     {textMarshalerType, textUnmarshalerType},
+}
+
+// marshalerSet is a set of MarshalerKinds.
+type marshalerSet uint8
+
+// set adds k to the set if ok is true.
+func (ms *marshalerSet) set(k MarshalerKind, ok bool) {
+	if ok {
+		*ms |= 1 << uint(k)
+	}
+}
+
+// has returns true if k is in the set.
+func (ms marshalerSet) has(k MarshalerKind) bool {
+	return ms&(1<<uint(k)) != 0
+}
+
+// implements returns true if t or a pointer to t implements iface with
+// methods that are not promoted.
+func implements(t, iface reflect.Type) bool {
+	return (t.Implements(iface) || reflect.PtrTo(t).Implements(iface)) &&
+		!promoted(t, iface)
+}
+
+// promoted returns true if t is a struct whose methods implementing iface
+// are promoted from an embedded field and not declared by t or a pointer to
+// t. Promoted methods are ignored as they would otherwise take over
+// encoding of the whole struct. The struct is written field by field
+// instead, the embedded value by its' own methods.
+func promoted(t, iface reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < iface.NumMethod(); i++ {
+		if !declares(t, iface.Method(i).Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// declares returns true if t or a pointer to t declares a method name.
+// Methods promoted from embedded fields, as well as methods of t in method
+// set of a pointer to t, are wrappers generated by the compiler and are
+// told apart by their source file.
+func declares(t reflect.Type, name string) bool {
+	m, ok := t.MethodByName(name)
+	if !ok {
+		if m, ok = reflect.PtrTo(t).MethodByName(name); !ok {
+			return false
+		}
+	}
+	pc := m.Func.Pointer()
+	file, _ := runtime.FuncForPC(pc).FileLine(pc)
+	return file != "<autogenerated>"
+}
+
+// marshalerSets caches sets of marshalers by type,
+// map[reflect.Type][2]marshalerSet.
+var marshalerSets sync.Map
+
+// marshalers returns a set of kinds of marshalers type t implements for
+// encoding if enc is true or for decoding otherwise. Pointer and interface
+// types implement none.
+func marshalers(t reflect.Type, enc bool) marshalerSet {
+	i := 1
+	if enc {
+		i = 0
+	}
+	if sets, ok := marshalerSets.Load(t); ok {
+		return sets.([2]marshalerSet)[i]
+	}
+	var sets [2]marshalerSet
+	if t.Kind() != reflect.Ptr && t.Kind() != reflect.Interface {
+		for k := BinaryExMarshaler; k < numMarshalerKinds; k++ {
+			enc, dec := marshalerTypes[k][0], marshalerTypes[k][1]
+			switch k {
+			case BinaryExMarshaler:
+				sets[0].set(k, hasGenerated(t, enc))
+				sets[1].set(k, hasGenerated(t, dec))
+			case BinaryMarshaler:
+				sets[0].set(k, implements(t, enc))
+				sets[1].set(k, reflect.PtrTo(t).Implements(dec) && !promoted(t, dec))
+			default:
+				both := implements(t, enc) && reflect.PtrTo(t).Implements(dec) &&
+					!promoted(t, dec)
+				sets[0].set(k, both)
+				sets[1].set(k, both)
+			}
+		}
+		// Both directions must pick from the same kinds, or a value could
+		// be written by one marshaler and read by another.
+		if both := sets[0] & sets[1]; both != 0 {
+			sets[0], sets[1] = both, both
+		}
+	}
+	marshalerSets.Store(t, sets)
+	return sets[i]
+}
+
+// hasMarshaler returns true if t implements a marshaler of any kind.
+func hasMarshaler(t reflect.Type) bool {
+	return marshalers(t, true)|marshalers(t, false) != 0
+}
+
+// pickMarshaler returns the first kind in order that is in set ms or 0 if
+// there is none. A nil order is the default order.
+func pickMarshaler(order []MarshalerKind, ms marshalerSet) MarshalerKind {
+	if ms == 0 {
+		return 0
+	}
+	if order == nil {
+		order = defaultMarshalers
+	}
+	for _, k := range order {
+		if k > 0 && k < numMarshalerKinds && ms.has(k) {
+			return k
+		}
+	}
+	return 0
+}
+
+// newMarshalerEncoder returns a plan for type t implementing marshalers ms
+// that writes values using the marshaler picked by Marshalers option
+// or using refl if no marshaler is picked.
+func newMarshalerEncoder(t reflect.Type, ms marshalerSet, refl encFunc) encFunc {
+	gen := encMarshalerEx
+	if ms.has(BinaryExMarshaler) {
+		gen = newGeneratedEncoder(t, refl)
+	}
+	return func(e *Encoder, v reflect.Value) error {
+		switch k := pickMarshaler(e.opts.Marshalers, ms); k {
+		case 0:
+			return refl(e, v)
+		case BinaryExMarshaler:
+			return gen(e, v)
+		default:
+			return encMarshaler(e, v, k)
+		}
+	}
+}
+
+// encMarshaler writes v marshaled by marshaler of kind k as a byte slice.
+func encMarshaler(e *Encoder, v reflect.Value, k MarshalerKind) (err error) {
+	if !v.Type().Implements(marshalerTypes[k][0]) {
+		if !v.CanAddr() {
+			pv := reflect.New(v.Type())
+			pv.Elem().Set(v)
+			v = pv.Elem()
+		}
+		v = v.Addr()
+	}
+	var p []byte
+	switch m := v.Interface(); k {
+	case BinaryMarshaler:
+		p, err = m.(encoding.BinaryMarshaler).MarshalBinary()
+	case GobMarshaler:
+		p, err = m.(gob.GobEncoder).GobEncode()
+	default:
+		p, err = m.(encoding.TextMarshaler).MarshalText()
+	}
+	if err != nil {
+		return
+	}
+	return e.writeBytes(p)
+}
+
+// newMarshalerDecoder returns a plan for type t implementing marshalers ms
+// that reads values using the marshaler picked by Marshalers option or
+// using refl if no marshaler is picked.
+func newMarshalerDecoder(t reflect.Type, ms marshalerSet, refl decFunc) decFunc {
+	gen := decUnmarshalerEx
+	if ms.has(BinaryExMarshaler) {
+		gen = newGeneratedDecoder(t, refl)
+	}
+	return func(d *Decoder, v reflect.Value) error {
+		switch k := pickMarshaler(d.opts.Marshalers, ms); k {
+		case 0:
+			return refl(d, v)
+		case BinaryExMarshaler:
+			return gen(d, v)
+		default:
+			return decMarshaler(d, v, k)
+		}
+	}
+}
+
+// decMarshaler reads a byte slice and unmarshals it into v using the
+// unmarshaler of kind k.
+func decMarshaler(d *Decoder, v reflect.Value, k MarshalerKind) error {
+	p, err := d.readBytes()
+	if err != nil {
+		return err
+	}
+	switch u := v.Addr().Interface(); k {
+	case BinaryMarshaler:
+		return u.(encoding.BinaryUnmarshaler).UnmarshalBinary(p)
+	case GobMarshaler:
+		return u.(gob.GobDecoder).GobDecode(p)
+	default:
+		return u.(encoding.TextUnmarshaler).UnmarshalText(p)
+	}
+}
+
+// bytesMarshaler returns the kind picked by order from set ms if it is a
+// kind that writes values as byte slices or 0 otherwise.
+func bytesMarshaler(order []MarshalerKind, ms marshalerSet) MarshalerKind {
+	if k := pickMarshaler(order, ms); k != BinaryExMarshaler {
+		return k
+	}
+	return 0
+}
@@ -0,0 +1,229 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+type TextEnum int
+
+func (te TextEnum) MarshalText() ([]byte, error) {
+	return []byte("enum" + strconv.Itoa(int(te))), nil
+}
+
+func (te *TextEnum) UnmarshalText(p []byte) error {
+	if !bytes.HasPrefix(p, []byte("enum")) {
+		return errors.New("invalid enum")
+	}
+	n, err := strconv.Atoi(string(p[4:]))
+	*te = TextEnum(n)
+	return err
+}
+
+type GobPoint struct {
+	X, Y int8
+}
+
+func (gp GobPoint) GobEncode() ([]byte, error) {
+	return []byte{byte(gp.X), byte(gp.Y)}, nil
+}
+
+func (gp *GobPoint) GobDecode(p []byte) error {
+	if len(p) != 2 {
+		return errors.New("invalid point")
+	}
+	gp.X, gp.Y = int8(p[0]), int8(p[1])
+	return nil
+}
+
+// MultiMarshaler implements Binary and Text marshalers differently.
+type MultiMarshaler struct {
+	V uint8
+}
+
+func (mm MultiMarshaler) MarshalBinary() ([]byte, error) {
+	return []byte{'b', mm.V}, nil
+}
+
+func (mm *MultiMarshaler) UnmarshalBinary(p []byte) error {
+	if len(p) != 2 || p[0] != 'b' {
+		return errors.New("not binary")
+	}
+	mm.V = p[1]
+	return nil
+}
+
+func (mm MultiMarshaler) MarshalText() ([]byte, error) {
+	return []byte{'t', mm.V}, nil
+}
+
+func (mm *MultiMarshaler) UnmarshalText(p []byte) error {
+	if len(p) != 2 || p[0] != 't' {
+		return errors.New("not text")
+	}
+	mm.V = p[1]
+	return nil
+}
+
+// TextOnly implements only the encoding half of TextMarshaler.
+type TextOnly struct {
+	V int
+}
+
+func (to TextOnly) MarshalText() ([]byte, error) {
+	return []byte("text"), nil
+}
+
+// HalfBinary implements only the encoding half of BinaryMarshaler and both
+// halves of TextMarshaler.
+type HalfBinary struct {
+	A uint8
+}
+
+func (hb HalfBinary) MarshalBinary() ([]byte, error) {
+	return []byte("bin"), nil
+}
+
+func (hb HalfBinary) MarshalText() ([]byte, error) {
+	return []byte{'t', hb.A}, nil
+}
+
+func (hb *HalfBinary) UnmarshalText(p []byte) error {
+	if len(p) != 2 || p[0] != 't' {
+		return errors.New("not text")
+	}
+	hb.A = p[1]
+	return nil
+}
+
+type MarshalerTypes struct {
+	Enum   TextEnum
+	Enums  []TextEnum
+	Point  GobPoint
+	Multi  MultiMarshaler
+	Text   TextOnly
+	Int    *big.Int
+	IP     net.IP
+	PEnum  *TextEnum
+	MapKey map[TextEnum]GobPoint
+}
+
+func (mt *MarshalerTypes) init() {
+	enum := TextEnum(9)
+	mt.Enum = 3
+	mt.Enums = []TextEnum{1, 2}
+	mt.Point = GobPoint{-1, 1}
+	mt.Multi = MultiMarshaler{7}
+	mt.Text = TextOnly{5}
+	mt.Int = new(big.Int).Lsh(big.NewInt(1), 100)
+	mt.IP = net.ParseIP("10.0.0.1")
+	mt.PEnum = &enum
+	mt.MapKey = map[TextEnum]GobPoint{4: {2, 3}}
+}
+
+func TestMarshalers(t *testing.T) {
+	var out MarshalerTypes
+	out.init()
+	for _, order := range [][]MarshalerKind{
+		nil,
+		{TextMarshaler, GobMarshaler, BinaryMarshaler},
+		{BinaryMarshaler},
+		{},
+	} {
+		for _, sd := range []bool{false, true} {
+			eopts := EncoderOptions{Marshalers: order, SelfDescribing: sd}
+			dopts := DecoderOptions{Marshalers: order, SelfDescribing: sd}
+			in := roundTrip(t, eopts, dopts, out).(MarshalerTypes)
+			// big.Int has no exported fields to write without marshalers.
+			intMarshaled := pickMarshaler(order, marshalers(reflect.TypeOf(*out.Int), true)) != 0
+			if in.Int == nil || intMarshaled && in.Int.Cmp(out.Int) != 0 {
+				t.Fatalf("%v, %t: big.Int missmatch: %v", order, sd, in.Int)
+			}
+			in.Int = out.Int
+			if !reflect.DeepEqual(in, out) {
+				t.Fatalf("%v, %t: Encode/Decode missmatch: in\n%v, out:\n%v\n", order, sd, in, out)
+			}
+			checkRoundTrip(t, eopts, dopts, out.Multi)
+		}
+	}
+}
+
+func TestMarshalersPrecedence(t *testing.T) {
+	for _, test := range []struct {
+		order []MarshalerKind
+		data  []byte
+	}{
+		{nil, []byte{4, 'b', 7}},
+		{[]MarshalerKind{TextMarshaler, BinaryMarshaler}, []byte{4, 't', 7}},
+		{[]MarshalerKind{GobMarshaler, TextMarshaler}, []byte{4, 't', 7}},
+		{[]MarshalerKind{}, []byte{7}},
+	} {
+		buf := bytes.NewBuffer(nil)
+		enc := NewEncoderWithOptions(buf, EncoderOptions{Marshalers: test.order})
+		if err := enc.Encode(MultiMarshaler{7}); err != nil {
+			t.Fatal("Encode failed", err)
+		}
+		if !bytes.Equal(buf.Bytes(), test.data) {
+			t.Fatalf("%v: expected % x, got % x", test.order, test.data, buf.Bytes())
+		}
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := Write(buf, TextEnum(3)); err != nil {
+		t.Fatal("Write failed", err)
+	}
+	if !bytes.Equal(buf.Bytes(), []byte{10, 'e', 'n', 'u', 'm', '3'}) {
+		t.Fatalf("unexpected encoding % x", buf.Bytes())
+	}
+}
+
+func TestMarshalersHalfBinary(t *testing.T) {
+	out := HalfBinary{7}
+	for _, order := range [][]MarshalerKind{nil, {BinaryMarshaler}, {BinaryMarshaler, TextMarshaler}} {
+		for _, sd := range []bool{false, true} {
+			checkRoundTrip(t, EncoderOptions{Marshalers: order, SelfDescribing: sd},
+				DecoderOptions{Marshalers: order, SelfDescribing: sd}, out)
+		}
+	}
+	data, err := Marshal(out)
+	if err != nil {
+		t.Fatal("Marshal failed", err)
+	}
+	if !bytes.Equal(data, []byte{4, 't', 7}) {
+		t.Fatalf("unexpected encoding % x", data)
+	}
+}
+
+func TestMarshalersSkip(t *testing.T) {
+	for _, order := range [][]MarshalerKind{nil, {}} {
+		buf := bytes.NewBuffer(nil)
+		enc := NewEncoderWithOptions(buf, EncoderOptions{Marshalers: order})
+		var out MarshalerTypes
+		out.init()
+		if err := enc.Encode(out); err != nil {
+			t.Fatal("Encode failed", err)
+		}
+		if err := enc.Encode(out.Enum); err != nil {
+			t.Fatal("Encode failed", err)
+		}
+		dec := NewDecoderWithOptions(buf, DecoderOptions{Marshalers: order})
+		if err := dec.Skip(reflect.TypeOf(out)); err != nil {
+			t.Fatal("Skip failed", err)
+		}
+		var enum TextEnum
+		if err := dec.Decode(&enum); err != nil {
+			t.Fatal("Decode failed", err)
+		}
+		if enum != out.Enum {
+			t.Fatalf("%v: expected %d, got %d", order, out.Enum, enum)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+// NilMode specifies how nil pointers, slices and maps are encoded.
+//
+// Writing and reading side must use the same NilMode.
+type NilMode int
+
+const (
+	// NilLegacy writes a nil pointer as a 0 and nil slices and maps as
+	// empty ones. When read, pointers are always allocated and set to the
+	// zero value of their type if nil was written and slices and maps are
+	// always non-nil.
+	//
+	// A nil pointer to a value that is not written as a single 0 byte, like
+	// a struct, desynchronises the stream when read. Embedded pointers are
+	// an exception and are written as in NilPreserve mode, see TagName.
+	NilLegacy NilMode = iota
+	// NilPreserve writes pointers prefixed by a presence marker and nil
+	// slices and maps with a length of -1 so nil values are read as nil.
+	NilPreserve
+)
+
+// IntEncoding specifies how ints and uints are encoded.
+//
+// Writing and reading side must use the same IntEncoding.
+type IntEncoding int
+
+const (
+	// IntZigZag writes ints as zig-zag encoded VarInts, so that numbers
+	// of small magnitude are short regardless of their sign, and uints as
+	// UVarInts. It is the default.
+	IntZigZag IntEncoding = iota
+	// IntVarint writes ints and uints as UVarInts of their two's
+	// complement value. Negative ints are always 10 bytes long.
+	IntVarint
+	// IntFixed writes ints and uints as fixed width little endian numbers
+	// of the size of their type. Ints and uints are 64 bit wide.
+	IntFixed
+	// IntFixedBE writes ints and uints as fixed width big endian numbers
+	// of the size of their type. Ints and uints are 64 bit wide.
+	IntFixedBE
+)
+
+// EncoderOptions holds Encoder options.
+type EncoderOptions struct {
+	// NilMode specifies how nil values are written.
+	NilMode NilMode
+	// SelfDescribing, if set, writes a stream header before the first value
+	// and a descriptor of the type of each value before it. Descriptors of
+	// compound types are written once per stream. Struct field descriptors
+	// contain field ordinals, names and types which allows a Decoder to read
+	// values into structs that have since changed.
+	SelfDescribing bool
+	// Canonical, if set, writes map entries sorted by key so that equal
+	// values are always written as equal bytes. Keys of bool, integer, float
+	// and string kinds are sorted by value and keys of other kinds by their
+	// encoding. Maps with keys that can not be ordered, like NaN floats or
+	// distinct pointers to equal values, fail with ErrNotCanonical.
+	Canonical bool
+	// Packed, if set, writes arrays and slices of numbers as a contiguous
+	// block of fixed width little endian numbers instead of element by
+	// element. Ints and uints are written as 64 bit numbers. Individual
+	// struct fields can be packed using the "packed" tag option.
+	Packed bool
+	// WideFloats, if set, writes float32 and complex64 values widened to
+	// float64 and complex128 as versions before native widths did. Elements
+	// of packed arrays and slices are always written at native width. It is
+	// recorded in the header of a self describing stream.
+	WideFloats bool
+	// IntEncoding specifies how ints and uints are written. It does not
+	// apply to elements of byte arrays and slices and of packed arrays and
+	// slices. Individual struct fields can override it with a tag option.
+	IntEncoding IntEncoding
+	// References, if set, writes each pointer once per top level value and
+	// later appearances of the same pointer as references to it, so that
+	// shared pointers stay shared and cyclic values can be written. Maps and
+	// slices are not tracked and are written as copies. Nil pointers are
+	// written as references regardless of NilMode. It is recorded in the
+	// header of a self describing stream.
+	//
+	// Without it, pointers, maps and slices that contain themselves fail
+	// with ErrCycle.
+	References bool
+	// Marshalers lists kinds of marshaler methods used to write values of
+	// types that implement them, in order of precedence. Values of types
+	// that implement none of the listed kinds are written by reflection.
+	// Nil means BinaryExMarshaler, BinaryMarshaler, GobMarshaler and
+	// TextMarshaler in that order; an empty slice disables all of them.
+	Marshalers []MarshalerKind
+}
+
+// DecoderOptions holds Decoder options.
+type DecoderOptions struct {
+	// NilMode specifies how nil values are read. It is ignored if
+	// SelfDescribing is set and the mode recorded in stream header is used.
+	NilMode NilMode
+	// SelfDescribing specifies that the stream was written by an Encoder
+	// with SelfDescribing option set.
+	//
+	// Struct fields are then matched to fields written by ordinal or by
+	// name if they have no ordinal. Written fields that do not exist in the
+	// struct are skipped and fields that were not written are set to zero
+	// values. Values of other types must be of compatible kinds.
+	SelfDescribing bool
+	// Canonical, if set, requires map entries to be sorted by key as written
+	// by an Encoder with Canonical option set. Decoding a map whose keys are
+	// out of order or repeated fails with ErrNotCanonical. Keys are compared
+	// as read, after conversion to the key type of the map.
+	Canonical bool
+	// Packed specifies that arrays and slices of numbers were written by an
+	// Encoder with Packed option set. It is ignored if SelfDescribing is
+	// set.
+	Packed bool
+	// WideFloats specifies that float32 and complex64 values were written
+	// widened to float64 and complex128 by an Encoder with WideFloats option
+	// set or by a version before native widths. It is ignored if
+	// SelfDescribing is set and the choice recorded in stream header is used.
+	WideFloats bool
+	// IntEncoding specifies how ints and uints were written. It is ignored
+	// if SelfDescribing is set.
+	IntEncoding IntEncoding
+	// References specifies that pointers were written by an Encoder with
+	// References option set. Pointers read from a reference to the same
+	// pointer point to the same value. It is ignored if SelfDescribing is
+	// set and the choice recorded in stream header is used.
+	References bool
+	// Marshalers lists kinds of marshaler methods used to read values of
+	// types that implement them, in order of precedence, and must match the
+	// Marshalers option values were written with, even if SelfDescribing
+	// is set.
+	Marshalers []MarshalerKind
+	// AliasBytes, if set, makes byte slices and payloads of unmarshalers
+	// read from a byte slice by Unmarshal or a Decoder returned by
+	// NewSliceDecoder reference that slice instead of being copied. The
+	// slice must then not be modified while values read from it are in
+	// use. It has no effect when reading from an io.Reader.
+	AliasBytes bool
+
+	// MaxSliceLen is the maximum number of elements of a slice, the length
+	// of a byte slice and the number of elements of an array described in
+	// a self describing stream. 0 means no limit, except for elements read
+	// as no bytes at all, like empty structs, of which at most 1<<20 are
+	// read so that a hostile length can not make reading loop for long.
+	MaxSliceLen int
+	// MaxMapLen is the maximum number of map entries. 0 means no limit,
+	// with the same exception as for MaxSliceLen.
+	MaxMapLen int
+	// MaxStringLen is the maximum length of a string in bytes. 0 means no
+	// limit.
+	MaxStringLen int
+	// MaxTotalBytes is the maximum number of bytes read for a single value
+	// by Decode or a package level Read function. 0 means no limit.
+	MaxTotalBytes int64
+	// MaxDepth is the maximum nesting depth of pointers, arrays, slices,
+	// maps, structs and interfaces. 0 means no limit, except for values
+	// read by a self-describing type descriptor, whose depth is limited to
+	// 65536 so that a hostile descriptor referring to itself can not
+	// overflow the stack.
+	MaxDepth int
+}
+
+var (
+	// DefaultEncoderOptions are the options used by NewEncoder and package
+	// level Write functions. Modifying them while encoding is not safe.
+	DefaultEncoderOptions = EncoderOptions{}
+	// DefaultDecoderOptions are the options used by NewDecoder and package
+	// level Read functions. Modifying them while decoding is not safe.
+	DefaultDecoderOptions = DecoderOptions{}
+)
@@ -0,0 +1,360 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+)
+
+// packedChunk is the size of the buffer packed arrays and slices are
+// encoded into and decoded from. It is a multiple of all fixed sizes.
+const packedChunk = 4096
+
+// fixedSize returns the size in bytes of a number of kind k written as a
+// fixed width little endian number or 0 if k is not a number kind.
+func fixedSize(k reflect.Kind) int {
+	switch k {
+	case reflect.Int8, reflect.Uint8:
+		return 1
+	case reflect.Int16, reflect.Uint16:
+		return 2
+	case reflect.Int32, reflect.Uint32, reflect.Float32:
+		return 4
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64,
+		reflect.Float64, reflect.Complex64:
+		return 8
+	case reflect.Complex128:
+		return 16
+	}
+	return 0
+}
+
+// isFixedType returns true if t is a number type that is written as a fixed
+// width number when it is an element of a packed array or slice.
+func isFixedType(t reflect.Type) bool {
+	return fixedSize(t.Kind()) > 0 && !hasMarshaler(t)
+}
+
+// isPackable returns true if t is an array or a slice of numbers.
+func isPackable(t reflect.Type) bool {
+	return (t.Kind() == reflect.Array || t.Kind() == reflect.Slice) &&
+		isFixedType(t.Elem())
+}
+
+// fixedSlices holds slice types of common number types that are packed
+// without reflecting on each element.
+var fixedSlices = map[reflect.Type]reflect.Type{
+	reflect.TypeOf(int32(0)):   reflect.TypeOf([]int32(nil)),
+	reflect.TypeOf(int64(0)):   reflect.TypeOf([]int64(nil)),
+	reflect.TypeOf(float32(0)): reflect.TypeOf([]float32(nil)),
+	reflect.TypeOf(float64(0)): reflect.TypeOf([]float64(nil)),
+}
+
+// fixedSlice returns n elements of array or slice v starting at index i as
+// a slice of a common number type or nil if v is not of such type.
+func fixedSlice(v reflect.Value, i, n int) interface{} {
+	st, ok := fixedSlices[v.Type().Elem()]
+	if !ok || v.Kind() == reflect.Array && !v.CanAddr() || !v.CanInterface() {
+		return nil
+	}
+	return v.Slice(i, i+n).Convert(st).Interface()
+}
+
+// putFixed writes n elements of array or slice v starting at index i to p
+// as fixed width little endian numbers.
+func putFixed(p []byte, v reflect.Value, i, n int) {
+	le := binary.LittleEndian
+	switch s := fixedSlice(v, i, n).(type) {
+	case []int32:
+		for j, x := range s {
+			le.PutUint32(p[4*j:], uint32(x))
+		}
+		return
+	case []int64:
+		for j, x := range s {
+			le.PutUint64(p[8*j:], uint64(x))
+		}
+		return
+	case []float32:
+		for j, x := range s {
+			le.PutUint32(p[4*j:], math.Float32bits(x))
+		}
+		return
+	case []float64:
+		for j, x := range s {
+			le.PutUint64(p[8*j:], math.Float64bits(x))
+		}
+		return
+	}
+	switch v.Type().Elem().Kind() {
+	case reflect.Int8:
+		for j := 0; j < n; j++ {
+			p[j] = byte(v.Index(i + j).Int())
+		}
+	case reflect.Int16:
+		for j := 0; j < n; j++ {
+			le.PutUint16(p[2*j:], uint16(v.Index(i+j).Int()))
+		}
+	case reflect.Int32:
+		for j := 0; j < n; j++ {
+			le.PutUint32(p[4*j:], uint32(v.Index(i+j).Int()))
+		}
+	case reflect.Int, reflect.Int64:
+		for j := 0; j < n; j++ {
+			le.PutUint64(p[8*j:], uint64(v.Index(i+j).Int()))
+		}
+	case reflect.Uint8:
+		for j := 0; j < n; j++ {
+			p[j] = byte(v.Index(i + j).Uint())
+		}
+	case reflect.Uint16:
+		for j := 0; j < n; j++ {
+			le.PutUint16(p[2*j:], uint16(v.Index(i+j).Uint()))
+		}
+	case reflect.Uint32:
+		for j := 0; j < n; j++ {
+			le.PutUint32(p[4*j:], uint32(v.Index(i+j).Uint()))
+		}
+	case reflect.Uint, reflect.Uint64:
+		for j := 0; j < n; j++ {
+			le.PutUint64(p[8*j:], v.Index(i+j).Uint())
+		}
+	case reflect.Float32:
+		for j := 0; j < n; j++ {
+			le.PutUint32(p[4*j:], math.Float32bits(float32(v.Index(i+j).Float())))
+		}
+	case reflect.Float64:
+		for j := 0; j < n; j++ {
+			le.PutUint64(p[8*j:], math.Float64bits(v.Index(i+j).Float()))
+		}
+	case reflect.Complex64:
+		for j := 0; j < n; j++ {
+			c := v.Index(i + j).Complex()
+			le.PutUint32(p[8*j:], math.Float32bits(float32(real(c))))
+			le.PutUint32(p[8*j+4:], math.Float32bits(float32(imag(c))))
+		}
+	case reflect.Complex128:
+		for j := 0; j < n; j++ {
+			c := v.Index(i + j).Complex()
+			le.PutUint64(p[16*j:], math.Float64bits(real(c)))
+			le.PutUint64(p[16*j+8:], math.Float64bits(imag(c)))
+		}
+	}
+}
+
+// getFixed reads n elements of array or slice v starting at index i from
+// fixed width little endian numbers in p.
+func getFixed(p []byte, v reflect.Value, i, n int) {
+	le := binary.LittleEndian
+	switch s := fixedSlice(v, i, n).(type) {
+	case []int32:
+		for j := range s {
+			s[j] = int32(le.Uint32(p[4*j:]))
+		}
+		return
+	case []int64:
+		for j := range s {
+			s[j] = int64(le.Uint64(p[8*j:]))
+		}
+		return
+	case []float32:
+		for j := range s {
+			s[j] = math.Float32frombits(le.Uint32(p[4*j:]))
+		}
+		return
+	case []float64:
+		for j := range s {
+			s[j] = math.Float64frombits(le.Uint64(p[8*j:]))
+		}
+		return
+	}
+	switch v.Type().Elem().Kind() {
+	case reflect.Int8:
+		for j := 0; j < n; j++ {
+			v.Index(i + j).SetInt(int64(int8(p[j])))
+		}
+	case reflect.Int16:
+		for j := 0; j < n; j++ {
+			v.Index(i + j).SetInt(int64(int16(le.Uint16(p[2*j:]))))
+		}
+	case reflect.Int32:
+		for j := 0; j < n; j++ {
+			v.Index(i + j).SetInt(int64(int32(le.Uint32(p[4*j:]))))
+		}
+	case reflect.Int, reflect.Int64:
+		for j := 0; j < n; j++ {
+			v.Index(i + j).SetInt(int64(le.Uint64(p[8*j:])))
+		}
+	case reflect.Uint8:
+		for j := 0; j < n; j++ {
+			v.Index(i + j).SetUint(uint64(p[j]))
+		}
+	case reflect.Uint16:
+		for j := 0; j < n; j++ {
+			v.Index(i + j).SetUint(uint64(le.Uint16(p[2*j:])))
+		}
+	case reflect.Uint32:
+		for j := 0; j < n; j++ {
+			v.Index(i + j).SetUint(uint64(le.Uint32(p[4*j:])))
+		}
+	case reflect.Uint, reflect.Uint64:
+		for j := 0; j < n; j++ {
+			v.Index(i + j).SetUint(le.Uint64(p[8*j:]))
+		}
+	case reflect.Float32:
+		for j := 0; j < n; j++ {
+			v.Index(i + j).SetFloat(float64(math.Float32frombits(le.Uint32(p[4*j:]))))
+		}
+	case reflect.Float64:
+		for j := 0; j < n; j++ {
+			v.Index(i + j).SetFloat(math.Float64frombits(le.Uint64(p[8*j:])))
+		}
+	case reflect.Complex64:
+		for j := 0; j < n; j++ {
+			v.Index(i + j).SetComplex(complex(
+				float64(math.Float32frombits(le.Uint32(p[8*j:]))),
+				float64(math.Float32frombits(le.Uint32(p[8*j+4:]))),
+			))
+		}
+	case reflect.Complex128:
+		for j := 0; j < n; j++ {
+			v.Index(i + j).SetComplex(complex(
+				math.Float64frombits(le.Uint64(p[16*j:])),
+				math.Float64frombits(le.Uint64(p[16*j+8:])),
+			))
+		}
+	}
+}
+
+// chunk returns a buffer for packed data of up to n elements of size bytes
+// and the number of elements it holds. The buffer is reused between calls.
+func chunk(buf *[]byte, n, size int) ([]byte, int) {
+	if n > packedChunk/size {
+		n = packedChunk / size
+	}
+	if cap(*buf) < n*size {
+		c := packedChunk
+		if n*size < c {
+			c = n * size
+		}
+		*buf = make([]byte, c)
+	}
+	return (*buf)[:n*size], n
+}
+
+// writePacked writes elements of a numeric array or slice v as a
+// contiguous block of fixed width little endian numbers.
+func (e *Encoder) writePacked(v reflect.Value) (err error) {
+	size := fixedSize(v.Type().Elem().Kind())
+	for i, l := 0, v.Len(); i < l; {
+		p, n := chunk(&e.scratch, l-i, size)
+		putFixed(p, v, i, n)
+		if err = e.write(p); err != nil {
+			return
+		}
+		i += n
+	}
+	return
+}
+
+// readPacked reads l elements of a numeric array or slice v starting at
+// index i written by writePacked.
+func (d *Decoder) readPacked(v reflect.Value, i, l int) (err error) {
+	size := fixedSize(v.Type().Elem().Kind())
+	if d.r.p != nil {
+		var p []byte
+		if p, err = d.r.next(l * size); err == nil {
+			getFixed(p, v, i, l)
+		}
+		return
+	}
+	for l > 0 {
+		p, n := chunk(&d.scratch, l, size)
+		if err = d.readFull(p); err != nil {
+			return
+		}
+		getFixed(p, v, i, n)
+		i += n
+		l -= n
+	}
+	return
+}
+
+// newPackedEncoder returns a plan that writes a numeric array or slice of
+// type t packed. Slices are prefixed by their length as usual.
+func newPackedEncoder(t reflect.Type) encFunc {
+	if t.Kind() == reflect.Array {
+		if isByteType(t.Elem()) {
+			return encByteArray
+		}
+		return func(e *Encoder, v reflect.Value) error {
+			return e.writePacked(v)
+		}
+	}
+	if isByteType(t.Elem()) {
+		return encByteSlice
+	}
+	return func(e *Encoder, v reflect.Value) (err error) {
+		var isNil bool
+		if isNil, err = e.writeNilLen(v); isNil || err != nil {
+			return
+		}
+		if err = e.writeLen(v.Len()); err != nil {
+			return
+		}
+		return e.writePacked(v)
+	}
+}
+
+// newPackedDecoder returns a plan that reads a numeric array or slice of
+// type t written packed. Slices are grown as elements are read.
+func newPackedDecoder(t reflect.Type) decFunc {
+	if t.Kind() == reflect.Array {
+		if isByteType(t.Elem()) {
+			return decByteArray
+		}
+		return func(d *Decoder, v reflect.Value) error {
+			return d.readPacked(v, 0, v.Len())
+		}
+	}
+	if isByteType(t.Elem()) {
+		return decByteSlice
+	}
+	return func(d *Decoder, v reflect.Value) error {
+		l, err := d.readNilLen(d.opts.MaxSliceLen)
+		if err != nil {
+			return err
+		}
+		if l < 0 {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		return d.readPackedSlice(v, l)
+	}
+}
+
+// readPackedSlice reads l packed elements into slice v. The slice is grown
+// as elements are read.
+func (d *Decoder) readPackedSlice(v reflect.Value, l int) (err error) {
+	t := v.Type()
+	size := fixedSize(t.Elem().Kind())
+	n := preallocLen(l, uintptr(size))
+	s := reflect.MakeSlice(t, n, n)
+	for i := 0; i < l; {
+		m := l - i
+		if m > packedChunk/size {
+			m = packedChunk / size
+		}
+		s = growSlice(s, i+m-1, l)
+		if err = d.readPacked(s, i, m); err != nil {
+			return
+		}
+		i += m
+	}
+	v.Set(s)
+	return
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type PackedTypes struct {
+	Floats    []float64   `binaryex:",packed"`
+	Float32s  []float32   `binaryex:",packed"`
+	Shorts    [4]int16    `binaryex:",packed"`
+	Uints     []uint32    `binaryex:",packed"`
+	Complexes []complex64 `binaryex:",packed"`
+	Named     []DerivedType
+	Ints      []int
+	Bytes     [3]byte `binaryex:",packed"`
+	Nil       []int64 `binaryex:",packed"`
+}
+
+func (pt *PackedTypes) init() {
+	pt.Shorts = [4]int16{-1, 2, -300, 32767}
+	pt.Bytes = [3]byte{1, 2, 3}
+	pt.Nil = []int64{}
+	for i := 0; i < 3000; i++ {
+		pt.Floats = append(pt.Floats, float64(i)/7)
+		pt.Float32s = append(pt.Float32s, float32(i)/7)
+		pt.Uints = append(pt.Uints, uint32(i)<<20)
+		pt.Complexes = append(pt.Complexes, complex(float32(i), -float32(i)))
+		pt.Named = append(pt.Named, DerivedType(i))
+		pt.Ints = append(pt.Ints, -i<<40)
+	}
+}
+
+func TestPacked(t *testing.T) {
+	var out PackedTypes
+	out.init()
+	for _, test := range []struct {
+		enc EncoderOptions
+		dec DecoderOptions
+	}{
+		{EncoderOptions{}, DecoderOptions{}},
+		{EncoderOptions{Packed: true}, DecoderOptions{Packed: true}},
+		{EncoderOptions{SelfDescribing: true}, DecoderOptions{SelfDescribing: true}},
+		{EncoderOptions{Packed: true, SelfDescribing: true}, DecoderOptions{SelfDescribing: true}},
+	} {
+		checkRoundTrip(t, test.enc, test.dec, out)
+	}
+}
+
+func TestPackedSize(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoderWithOptions(buf, EncoderOptions{Packed: true})
+	if err := enc.Encode([512]uint16{}); err != nil {
+		t.Fatal("Encode failed", err)
+	}
+	if buf.Len() != 1024 {
+		t.Fatalf("expected 1024 bytes, got %d", buf.Len())
+	}
+	buf.Reset()
+	if err := enc.Encode(make([]float64, 100)); err != nil {
+		t.Fatal("Encode failed", err)
+	}
+	if buf.Len() != 802 {
+		t.Fatalf("expected 802 bytes, got %d", buf.Len())
+	}
+}
+
+func TestPackedSelfDescribing(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoderWithOptions(buf, EncoderOptions{Packed: true, SelfDescribing: true})
+	for _, val := range []interface{}{
+		[]int32{1, -2, 3}, [3]uint16{4, 5, 6}, [3]uint16{4, 5, 6},
+		[]float32{0.5}, PackedTypes{Ints: []int{7}}, RecordItem{"a", 1},
+	} {
+		if err := enc.Encode(val); err != nil {
+			t.Fatal("Encode failed", err)
+		}
+	}
+	dec := NewDecoderWithOptions(buf, DecoderOptions{SelfDescribing: true})
+	var (
+		ints   []int64
+		short  [2]uint16
+		long   [4]uint64
+		floats []float64
+		packed struct{ Ints []int }
+		item   struct{ Price float64 }
+	)
+	for _, val := range []interface{}{&ints, &short, &long, &floats, &packed, &item} {
+		if err := dec.Decode(val); err != nil {
+			t.Fatalf("%T: Decode failed: %v", val, err)
+		}
+	}
+	if !reflect.DeepEqual(ints, []int64{1, -2, 3}) || short != [2]uint16{4, 5} ||
+		long != [4]uint64{4, 5, 6} || !reflect.DeepEqual(floats, []float64{0.5}) ||
+		!reflect.DeepEqual(packed.Ints, []int{7}) || item.Price != 1 {
+		t.Fatalf("Encode/Decode missmatch: %v, %v, %v, %v, %v, %v",
+			ints, short, long, floats, packed, item)
+	}
+}
+
+func TestPackedInvalidTag(t *testing.T) {
+	for _, val := range []interface{}{
+		struct {
+			S string `binaryex:",packed"`
+		}{},
+		struct {
+			S []string `binaryex:",packed"`
+		}{},
+	} {
+		if err := Write(bytes.NewBuffer(nil), val); !errors.Is(err, ErrInvalidTag) {
+			t.Fatalf("%T: expected ErrInvalidTag, got %v", val, err)
+		}
+	}
+}
+
+func BenchmarkPacked(b *testing.B) {
+	in := make([]float64, 1<<16)
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoderWithOptions(buf, EncoderOptions{Packed: true})
+	dec := NewDecoderWithOptions(buf, DecoderOptions{Packed: true})
+	var out []float64
+	b.SetBytes(int64(len(in) * 8))
+	for i := 0; i < b.N; i++ {
+		enc.Encode(in)
+		dec.Decode(&out)
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"reflect"
+)
+
+// Records are framed as:
+//
+//	marker   2 bytes, recordMarker.
+//	flags    1 byte, rfChecksum if the record has a checksum.
+//	length   VarInt length of the payload, as written by writeLen.
+//	payload  the value, encoded as by Marshal.
+//	checksum 4 bytes, little endian CRC32C of flags, length and payload,
+//	         if rfChecksum is set.
+//
+// A reader that encounters a corrupt record resynchronises by searching for
+// the next marker.
+
+// recordMarker starts every record.
+var recordMarker = [2]byte{0xBE, 0x52}
+
+// Record flags.
+const (
+	rfChecksum = 1 << iota
+)
+
+// recordHeaderLen is the length of a record marker and flags.
+const recordHeaderLen = 3
+
+// DefaultMaxRecordLen is the maximum length of a record payload in bytes
+// if RecordOptions.MaxRecordLen is 0.
+const DefaultMaxRecordLen = 64 << 20
+
+// crcTable is the CRC32C (Castagnoli) table used for record checksums.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// RecordOptions holds RecordWriter and RecordReader options.
+type RecordOptions struct {
+	// Checksum, if set, appends a CRC32C checksum to each record written by
+	// a RecordWriter. A RecordReader verifies checksums of records that
+	// have one regardless of it.
+	Checksum bool
+	// MaxRecordLen is the maximum length of a record payload in bytes.
+	// RecordWriter fails to write longer records with ErrLimitExceeded and
+	// RecordReader treats them as corrupt. 0 means DefaultMaxRecordLen.
+	MaxRecordLen int
+	// Resync, if set, makes RecordReader.Next skip corrupt data up to the
+	// next record instead of failing with ErrCorruptRecord.
+	Resync bool
+}
+
+// maxRecordLen returns the maximum length of a record payload.
+func (o RecordOptions) maxRecordLen() int {
+	if o.MaxRecordLen > 0 {
+		return o.MaxRecordLen
+	}
+	return DefaultMaxRecordLen
+}
+
+// RecordWriter writes values as length delimited records that can be read
+// individually by a RecordReader, so that a corrupt record does not
+// desynchronise records that follow it.
+type RecordWriter struct {
+	w       io.Writer
+	opts    RecordOptions
+	eopts   EncoderOptions
+	payload sliceWriter
+	frame   sliceWriter
+}
+
+// NewRecordWriter returns a new RecordWriter that writes to w using
+// DefaultEncoderOptions and no checksums.
+func NewRecordWriter(w io.Writer) *RecordWriter {
+	return NewRecordWriterWithOptions(w, RecordOptions{}, DefaultEncoderOptions)
+}
+
+// NewRecordWriterWithOptions returns a new RecordWriter that writes to w
+// using opts and encodes values using eopts.
+func NewRecordWriterWithOptions(w io.Writer, opts RecordOptions, eopts EncoderOptions) *RecordWriter {
+	return &RecordWriter{w: w, opts: opts, eopts: eopts}
+}
+
+// Write writes val as a record or returns an error if one occured.
+func (rw *RecordWriter) Write(val interface{}) error {
+	return rw.WriteValue(reflect.Indirect(reflect.ValueOf(val)))
+}
+
+// WriteValue writes a reflect value v as a record or returns an error if
+// one occured. Each value is encoded on its' own, as by Marshal, and a
+// record is written to the underlying writer in a single Write.
+func (rw *RecordWriter) WriteValue(v reflect.Value) (err error) {
+	if rw.w == nil {
+		return &EncodeError{Type: valueType(v), Path: joinPath(valueType(v), nil), Err: ErrInvalidArgument}
+	}
+	e := getEncoder(&rw.payload)
+	defer putEncoder(e)
+	e.opts = rw.eopts
+	rw.payload.p = rw.payload.p[:0]
+	if err = e.EncodeValue(v); err != nil {
+		return
+	}
+	if len(rw.payload.p) > rw.opts.maxRecordLen() {
+		return &EncodeError{Type: valueType(v), Path: joinPath(valueType(v), nil), Err: ErrLimitExceeded}
+	}
+	var flags byte
+	if rw.opts.Checksum {
+		flags |= rfChecksum
+	}
+	rw.frame.p = append(rw.frame.p[:0], recordMarker[0], recordMarker[1], flags)
+	e.w = &rw.frame
+	if err = e.writeLen(len(rw.payload.p)); err != nil {
+		return
+	}
+	rw.frame.p = append(rw.frame.p, rw.payload.p...)
+	if rw.opts.Checksum {
+		sum := crc32.Checksum(rw.frame.p[2:], crcTable)
+		rw.frame.p = append(rw.frame.p, byte(sum), byte(sum>>8), byte(sum>>16), byte(sum>>24))
+	}
+	_, err = rw.w.Write(rw.frame.p)
+	return
+}
+
+// RecordReader reads records written by a RecordWriter.
+//
+// Next advances to the next record whose value is then read by Decode:
+//
+//	rr := NewRecordReader(r)
+//	for rr.Next() {
+//		var v Value
+//		if err := rr.Decode(&v); err != nil {
+//			// The record is skipped, the following ones are still read.
+//		}
+//	}
+//	if err := rr.Err(); err != nil {
+//		// Reading failed.
+//	}
+type RecordReader struct {
+	r     io.Reader
+	opts  RecordOptions
+	dopts DecoderOptions
+	// buf holds data read from r, buf[pos:] is not consumed yet.
+	buf []byte
+	pos int
+	// base is the stream offset of buf[0].
+	base int64
+	eof  bool
+	err  error
+	// rec is the payload of the current record at offset off.
+	rec     []byte
+	ok      bool
+	off     int64
+	skipped int64
+}
+
+// NewRecordReader returns a new RecordReader that reads from r using
+// DefaultDecoderOptions.
+func NewRecordReader(r io.Reader) *RecordReader {
+	return NewRecordReaderWithOptions(r, RecordOptions{}, DefaultDecoderOptions)
+}
+
+// NewRecordReaderWithOptions returns a new RecordReader that reads from r
+// using opts and decodes values using dopts. AliasBytes option of dopts is
+// ignored.
+func NewRecordReaderWithOptions(r io.Reader, opts RecordOptions, dopts DecoderOptions) *RecordReader {
+	dopts.AliasBytes = false
+	rr := &RecordReader{r: r, opts: opts, dopts: dopts}
+	if r == nil {
+		rr.err = ErrInvalidArgument
+	}
+	return rr
+}
+
+// Next advances to the next record and returns true or returns false at
+// the end of the stream or if an error occured, which is then returned by
+// Err. A corrupt record fails with ErrCorruptRecord unless Resync option
+// is set in which case it is skipped.
+func (rr *RecordReader) Next() bool {
+	rr.rec, rr.ok = nil, false
+	for rr.err == nil {
+		n, err := rr.frame()
+		switch {
+		case err == nil:
+			rr.off = rr.base + int64(rr.pos)
+			rr.pos += n
+			rr.ok = true
+			return true
+		case err == io.EOF:
+			return false
+		case err == ErrCorruptRecord && rr.opts.Resync:
+			rr.resync()
+		default:
+			rr.err = err
+		}
+	}
+	return false
+}
+
+// Err returns the error that stopped Next or nil if the stream ended.
+func (rr *RecordReader) Err() error {
+	return rr.err
+}
+
+// Bytes returns the payload of the current record. It is valid until the
+// next call to Next.
+func (rr *RecordReader) Bytes() []byte {
+	return rr.rec
+}
+
+// Offset returns the stream offset of the current record.
+func (rr *RecordReader) Offset() int64 {
+	return rr.off
+}
+
+// Skipped returns the number of bytes skipped while resynchronising after
+// corrupt records.
+func (rr *RecordReader) Skipped() int64 {
+	return rr.skipped
+}
+
+// Decode reads the value of the current record into val which must be a
+// pointer or returns an error if one occured. See Read for details.
+func (rr *RecordReader) Decode(val interface{}) error {
+	return rr.DecodeValue(reflect.Indirect(reflect.ValueOf(val)))
+}
+
+// DecodeValue reads the value of the current record into v which must be
+// addressable or returns an error if one occured. Data of the record after
+// the value is ignored. An error does not affect reading of the following
+// records.
+func (rr *RecordReader) DecodeValue(v reflect.Value) error {
+	if !rr.ok {
+		return &DecodeError{Type: valueType(v), Path: joinPath(valueType(v), nil), Err: ErrInvalidArgument}
+	}
+	return NewSliceDecoder(rr.rec, rr.dopts).DecodeValue(v)
+}
+
+// fill reads from r until at least n bytes are buffered after pos and
+// returns true or returns false if r ended or failed before.
+func (rr *RecordReader) fill(n int) bool {
+	for len(rr.buf)-rr.pos < n && !rr.eof && rr.err == nil {
+		if rr.pos > 0 {
+			rr.base += int64(rr.pos)
+			rr.buf = rr.buf[:copy(rr.buf, rr.buf[rr.pos:])]
+			rr.pos = 0
+		}
+		if cap(rr.buf) < n {
+			c := 2 * cap(rr.buf)
+			if c < 4096 {
+				c = 4096
+			}
+			if c < n {
+				c = n
+			}
+			buf := make([]byte, len(rr.buf), c)
+			copy(buf, rr.buf)
+			rr.buf = buf
+		}
+		m, err := rr.r.Read(rr.buf[len(rr.buf):cap(rr.buf)])
+		rr.buf = rr.buf[:len(rr.buf)+m]
+		if err == io.EOF {
+			rr.eof = true
+		} else if err != nil {
+			rr.err = err
+		}
+	}
+	return len(rr.buf)-rr.pos >= n
+}
+
+// frame reads the record at pos into rec and returns its' length. It
+// returns io.EOF at the end of the stream and ErrCorruptRecord if the record
+// is not valid.
+func (rr *RecordReader) frame() (n int, err error) {
+	if !rr.fill(recordHeaderLen) {
+		switch {
+		case rr.err != nil:
+			return 0, rr.err
+		case len(rr.buf) == rr.pos:
+			return 0, io.EOF
+		}
+		return 0, ErrCorruptRecord
+	}
+	p := rr.buf[rr.pos:]
+	if p[0] != recordMarker[0] || p[1] != recordMarker[1] || p[2]&^rfChecksum != 0 {
+		return 0, ErrCorruptRecord
+	}
+	checksum := p[2]&rfChecksum != 0
+	rr.fill(recordHeaderLen + binary.MaxVarintLen64)
+	d := NewSliceDecoder(rr.buf[rr.pos+recordHeaderLen:], DecoderOptions{})
+	// Lengths are limited so that a corrupt one neither overflows nor
+	// buffers the rest of the stream before it is found corrupt.
+	l, err := d.readLen(rr.opts.maxRecordLen())
+	if err != nil {
+		if rr.err != nil {
+			return 0, rr.err
+		}
+		return 0, ErrCorruptRecord
+	}
+	start := recordHeaderLen + int(d.r.n)
+	n = start + l
+	if checksum {
+		n += 4
+	}
+	if !rr.fill(n) {
+		if rr.err != nil {
+			return 0, rr.err
+		}
+		return 0, ErrCorruptRecord
+	}
+	p = rr.buf[rr.pos : rr.pos+n]
+	if checksum {
+		sum := crc32.Checksum(p[2:n-4], crcTable)
+		if binary.LittleEndian.Uint32(p[n-4:]) != sum {
+			return 0, ErrCorruptRecord
+		}
+	}
+	rr.rec = p[start : start+l]
+	return
+}
+
+// resync discards data up to the next record marker after pos.
+func (rr *RecordReader) resync() {
+	from := rr.base + int64(rr.pos)
+	rr.pos++
+	for {
+		if i := bytes.Index(rr.buf[rr.pos:], recordMarker[:]); i >= 0 {
+			rr.pos += i
+			break
+		}
+		// Keep a last byte that can start a marker.
+		if n := len(rr.buf); n > rr.pos && rr.buf[n-1] == recordMarker[0] {
+			rr.pos = n - 1
+		} else {
+			rr.pos = n
+		}
+		if !rr.fill(len(rr.buf) - rr.pos + 1) {
+			rr.pos = len(rr.buf)
+			break
+		}
+	}
+	rr.skipped += rr.base + int64(rr.pos) - from
+}
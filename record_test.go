@@ -0,0 +1,162 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+func writeRecords(t *testing.T, opts RecordOptions, eopts EncoderOptions, vals ...interface{}) []byte {
+	buf := bytes.NewBuffer(nil)
+	rw := NewRecordWriterWithOptions(buf, opts, eopts)
+	for _, val := range vals {
+		if err := rw.Write(val); err != nil {
+			t.Fatal("Write failed", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestRecords(t *testing.T) {
+	var order Order
+	order.init()
+	out := order.Items
+	for _, opts := range []RecordOptions{{}, {Checksum: true}} {
+		for _, sd := range []bool{false, true} {
+			data := writeRecords(t, opts, EncoderOptions{SelfDescribing: sd}, out[0], out[1], &out[2], out[3])
+			// Read one byte at a time to exercise buffering.
+			rr := NewRecordReaderWithOptions(iotest.OneByteReader(bytes.NewReader(data)),
+				opts, DecoderOptions{SelfDescribing: sd})
+			var in []OrderItem
+			for rr.Next() {
+				var o OrderItem
+				if err := rr.Decode(&o); err != nil {
+					t.Fatal("Decode failed", err)
+				}
+				in = append(in, o)
+			}
+			if err := rr.Err(); err != nil {
+				t.Fatal("Next failed", err)
+			}
+			if !reflect.DeepEqual(in, out) {
+				t.Fatalf("%+v, %t: Write/Read missmatch: in\n%v, out:\n%v\n", opts, sd, in, out)
+			}
+		}
+	}
+}
+
+func TestRecordsDecodeError(t *testing.T) {
+	data := writeRecords(t, RecordOptions{}, EncoderOptions{}, "text", 1, "more")
+	rr := NewRecordReader(bytes.NewReader(data))
+	var s []string
+	for rr.Next() {
+		var v string
+		if err := rr.Decode(&v); err != nil {
+			continue
+		}
+		s = append(s, v)
+	}
+	if rr.Err() != nil || !reflect.DeepEqual(s, []string{"text", "more"}) {
+		t.Fatalf("unexpected records %v, %v", s, rr.Err())
+	}
+	if err := rr.Decode(new(string)); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("expected ErrInvalidArgument, got %v", err)
+	}
+}
+
+func TestRecordsResync(t *testing.T) {
+	opts := RecordOptions{Checksum: true}
+	first := writeRecords(t, opts, EncoderOptions{}, "first")
+	second := writeRecords(t, opts, EncoderOptions{}, "second")
+	third := writeRecords(t, opts, EncoderOptions{}, "third")
+	var data []byte
+	data = append(data, 0xBE, 0x00)
+	data = append(data, first...)
+	second[len(second)-6] ^= 0xFF
+	data = append(data, second...)
+	data = append(data, recordMarker[0])
+	data = append(data, third...)
+	data = append(data, third[:len(third)-2]...)
+
+	rr := NewRecordReaderWithOptions(bytes.NewReader(data), opts, DecoderOptions{})
+	if rr.Next() || !errors.Is(rr.Err(), ErrCorruptRecord) {
+		t.Fatalf("expected ErrCorruptRecord, got %v", rr.Err())
+	}
+
+	opts.Resync = true
+	rr = NewRecordReaderWithOptions(iotest.HalfReader(bytes.NewReader(data)), opts, DecoderOptions{})
+	var s []string
+	for rr.Next() {
+		var v string
+		if err := rr.Decode(&v); err != nil {
+			t.Fatal("Decode failed", err)
+		}
+		s = append(s, v)
+	}
+	if err := rr.Err(); err != nil {
+		t.Fatal("Next failed", err)
+	}
+	if !reflect.DeepEqual(s, []string{"first", "third"}) {
+		t.Fatalf("unexpected records %v", s)
+	}
+	if skipped := int64(2 + len(second) + 1 + len(third) - 2); rr.Skipped() != skipped {
+		t.Fatalf("expected %d bytes skipped, got %d", skipped, rr.Skipped())
+	}
+	if offset := int64(2 + len(first) + len(second) + 1); rr.Offset() != offset {
+		t.Fatalf("expected offset %d, got %d", offset, rr.Offset())
+	}
+}
+
+func TestRecordsMaxLen(t *testing.T) {
+	data := writeRecords(t, RecordOptions{}, EncoderOptions{}, "long value", "short")
+	rr := NewRecordReaderWithOptions(bytes.NewReader(data),
+		RecordOptions{MaxRecordLen: 8, Resync: true}, DecoderOptions{})
+	var s []string
+	for rr.Next() {
+		var v string
+		if err := rr.Decode(&v); err != nil {
+			t.Fatal("Decode failed", err)
+		}
+		s = append(s, v)
+	}
+	if rr.Err() != nil || !reflect.DeepEqual(s, []string{"short"}) {
+		t.Fatalf("unexpected records %v, %v", s, rr.Err())
+	}
+}
+
+// recordHeader returns a record header with a length l.
+func recordHeader(l int64) []byte {
+	p := make([]byte, recordHeaderLen+binary.MaxVarintLen64)
+	copy(p, recordMarker[:])
+	return p[:recordHeaderLen+binary.PutVarint(p[recordHeaderLen:], l)]
+}
+
+func TestRecordsHostileLen(t *testing.T) {
+	for _, l := range []int64{1<<63 - 1, 1<<62 + 5, DefaultMaxRecordLen + 1} {
+		frame := recordHeader(l)
+		rr := NewRecordReader(bytes.NewReader(append(frame, "data"...)))
+		if rr.Next() || !errors.Is(rr.Err(), ErrCorruptRecord) {
+			t.Fatalf("%d: expected ErrCorruptRecord, got %v", l, rr.Err())
+		}
+	}
+	data := writeRecords(t, RecordOptions{}, EncoderOptions{}, "short")
+	frame := recordHeader(1<<63 - 1)
+	rr := NewRecordReaderWithOptions(bytes.NewReader(append(frame, data...)), RecordOptions{Resync: true}, DecoderOptions{})
+	var s string
+	if !rr.Next() || rr.Decode(&s) != nil || s != "short" || rr.Skipped() != int64(len(frame)) {
+		t.Fatalf("unexpected record %q, skipped %d, %v", s, rr.Skipped(), rr.Err())
+	}
+
+	buf := bytes.NewBuffer(nil)
+	rw := NewRecordWriterWithOptions(buf, RecordOptions{MaxRecordLen: 4}, DefaultEncoderOptions)
+	if err := rw.Write("long value"); !errors.Is(err, ErrLimitExceeded) || buf.Len() != 0 {
+		t.Fatalf("expected ErrLimitExceeded, got %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import "reflect"
+
+// cycleCheckLevel is the nesting level of pointers, slices and maps after
+// which an Encoder starts checking for cycles. Checking every value would
+// slow down encoding of values without cycles which are the vast majority.
+const cycleCheckLevel = 1000
+
+// refKey identifies a pointer, a slice or a map. Pointers to a struct and
+// its' first field have the same address so the type is part of the key and
+// so is the length of a slice as slices of an array share its' address.
+type refKey struct {
+	ptr uintptr
+	len int
+	typ reflect.Type
+}
+
+// newRefKey returns a refKey of pointer, slice or map v.
+func newRefKey(v reflect.Value) refKey {
+	k := refKey{ptr: v.Pointer(), typ: v.Type()}
+	if v.Kind() == reflect.Slice {
+		k.len = v.Len()
+	}
+	return k
+}
+
+// resetRefs clears pointers written and being written.
+func (e *Encoder) resetRefs() {
+	for k := range e.refs {
+		delete(e.refs, k)
+	}
+	for k := range e.visiting {
+		delete(e.visiting, k)
+	}
+	e.level = 0
+}
+
+// enterRef increases the nesting level before pointer, slice or map v is
+// written. Past cycleCheckLevel it returns ErrCycle if v is already being
+// written. A successful enterRef must be followed by a leaveRef.
+func (e *Encoder) enterRef(v reflect.Value) error {
+	if e.level++; e.level <= cycleCheckLevel {
+		return nil
+	}
+	k := newRefKey(v)
+	if _, ok := e.visiting[k]; ok {
+		return ErrCycle
+	}
+	if e.visiting == nil {
+		e.visiting = make(map[refKey]struct{})
+	}
+	e.visiting[k] = struct{}{}
+	return nil
+}
+
+// leaveRef decreases the nesting level after v was written.
+func (e *Encoder) leaveRef(v reflect.Value) {
+	if e.level > cycleCheckLevel {
+		delete(e.visiting, newRefKey(v))
+	}
+	e.level--
+}
+
+// writeRef writes pointer v in References mode as an UVarInt reference
+// followed by the value it points to if it was not written before.
+//
+// Pointers are assigned ids in order of their first appearance in a value.
+// Reference 0 is a nil pointer, 1 a pointer that was not written before
+// and a reference greater than 1 is the id of a pointer written before
+// plus 2.
+func (e *Encoder) writeRef(v reflect.Value, elem encFunc) error {
+	if v.IsNil() {
+		return e.writeUvarint(0)
+	}
+	k := newRefKey(v)
+	if id, ok := e.refs[k]; ok {
+		return e.writeUvarint(id + 2)
+	}
+	if e.refs == nil {
+		e.refs = make(map[refKey]uint64)
+	}
+	e.refs[k] = uint64(len(e.refs))
+	if err := e.writeUvarint(1); err != nil {
+		return err
+	}
+	return elem(e, v.Elem())
+}
+
+// resetRefs clears pointers read.
+func (d *Decoder) resetRefs() {
+	for i := range d.refs {
+		d.refs[i] = reflect.Value{}
+	}
+	d.refs = d.refs[:0]
+}
+
+// readRef reads a pointer written by writeRef into v.
+func (d *Decoder) readRef(v reflect.Value, elem decFunc) error {
+	ref, err := d.readUvarint()
+	if err != nil {
+		return err
+	}
+	switch {
+	case ref == 0:
+		v.Set(reflect.Zero(v.Type()))
+	case ref == 1:
+		pv := reflect.New(v.Type().Elem())
+		d.refs = append(d.refs, pv)
+		if err = elem(d, pv.Elem()); err != nil {
+			return err
+		}
+		v.Set(pv)
+	case ref-2 < uint64(len(d.refs)):
+		pv := d.refs[ref-2]
+		if pv.Type() != v.Type() {
+			return ErrUnexpected
+		}
+		v.Set(pv)
+	default:
+		return ErrUnexpected
+	}
+	return nil
+}
+
+// decodeWireRef reads a pointer to a value of wire type wt written by
+// writeRef into v which may be a pointer or the value itself. A pointer
+// written before is shared with v if v is a pointer and copied otherwise.
+func (d *Decoder) decodeWireRef(wt *wireType, v reflect.Value) (err error) {
+	ref, err := d.readUvarint()
+	if err != nil {
+		return
+	}
+	t := v.Type()
+	switch {
+	case ref == 0:
+		v.Set(reflect.Zero(t))
+	case ref == 1:
+		et := t
+		if t.Kind() == reflect.Ptr {
+			et = t.Elem()
+		}
+		pv := reflect.New(et)
+		d.refs = append(d.refs, pv)
+		if err = d.decodeWire(wt, pv.Elem()); err != nil {
+			return
+		}
+		if t.Kind() == reflect.Ptr {
+			v.Set(pv)
+		} else {
+			v.Set(pv.Elem())
+		}
+	case ref-2 < uint64(len(d.refs)):
+		pv := d.refs[ref-2]
+		switch {
+		case !pv.IsValid():
+			return ErrIncompatibleType
+		case pv.Type().AssignableTo(t):
+			v.Set(pv)
+		case pv.Type().Elem().AssignableTo(t):
+			v.Set(pv.Elem())
+		default:
+			return ErrIncompatibleType
+		}
+	default:
+		return ErrUnexpected
+	}
+	return
+}
+
+// skipWireRef reads and discards a pointer to a value of wire type wt
+// written by writeRef.
+func (d *Decoder) skipWireRef(wt *wireType) (err error) {
+	ref, err := d.readUvarint()
+	if err != nil || ref != 1 {
+		return
+	}
+	// Skipped values can not be referenced.
+	d.refs = append(d.refs, reflect.Value{})
+	return d.skipWire(wt)
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type RefNode struct {
+	Name string
+	Next *RefNode
+	Peer *RefNode
+}
+
+type RefGraph struct {
+	Head   *RefNode
+	Shared *RefNode
+	Nil    *RefNode
+	Nodes  []*RefNode
+}
+
+type RefSlice []RefSlice
+
+func (g *RefGraph) init() {
+	a, b, c := &RefNode{Name: "a"}, &RefNode{Name: "b"}, &RefNode{Name: "c"}
+	a.Next, b.Next, c.Next = b, c, a
+	b.Peer = b
+	*g = RefGraph{Head: a, Shared: c, Nodes: []*RefNode{c, b, a, c}}
+}
+
+func checkRefGraph(t *testing.T, g RefGraph) {
+	a := g.Head
+	if a == nil || a.Next == nil || a.Next.Next == nil {
+		t.Fatalf("Encode/Decode missmatch: %v", g)
+	}
+	b, c := a.Next, a.Next.Next
+	if a.Name != "a" || b.Name != "b" || c.Name != "c" || c.Next != a ||
+		b.Peer != b || a.Peer != nil || g.Shared != c || g.Nil != nil ||
+		len(g.Nodes) != 4 || g.Nodes[0] != c || g.Nodes[1] != b ||
+		g.Nodes[2] != a || g.Nodes[3] != c {
+		t.Fatalf("Encode/Decode missmatch: %v", g)
+	}
+}
+
+func TestCycle(t *testing.T) {
+	n := &RefNode{Name: "loop"}
+	n.Next = n
+	s := make(RefSlice, 1)
+	s[0] = s
+	var g RefGraph
+	g.init()
+	for _, val := range []interface{}{n, g, s} {
+		if err := Write(bytes.NewBuffer(nil), val); !errors.Is(err, ErrCycle) {
+			t.Fatalf("%T: expected ErrCycle, got %v", val, err)
+		}
+	}
+	// Deep values without cycles are fine.
+	var deep *RefNode
+	for i := 0; i < 2*cycleCheckLevel; i++ {
+		deep = &RefNode{Next: deep}
+	}
+	if err := Write(bytes.NewBuffer(nil), deep); err != nil {
+		t.Fatal("Write failed", err)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	var out RefGraph
+	out.init()
+	for _, sd := range []bool{false, true} {
+		buf := bytes.NewBuffer(nil)
+		enc := NewEncoderWithOptions(buf, EncoderOptions{References: true, SelfDescribing: sd})
+		for i := 0; i < 2; i++ {
+			if err := enc.Encode(out); err != nil {
+				t.Fatal("Encode failed", err)
+			}
+		}
+		dec := NewDecoderWithOptions(buf, DecoderOptions{References: true, SelfDescribing: sd})
+		for i := 0; i < 2; i++ {
+			var in RefGraph
+			if err := dec.Decode(&in); err != nil {
+				t.Fatalf("%t: Decode failed: %v", sd, err)
+			}
+			checkRefGraph(t, in)
+		}
+	}
+}
+
+func TestReferencesSelfDescribing(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoderWithOptions(buf, EncoderOptions{References: true, SelfDescribing: true})
+	var g RefGraph
+	g.init()
+	if err := enc.Encode(g); err != nil {
+		t.Fatal("Encode failed", err)
+	}
+	// Head is skipped along with nodes it references.
+	var in struct {
+		Shared RefNode
+		Nil    *RefNode
+	}
+	dec := NewDecoderWithOptions(buf, DecoderOptions{SelfDescribing: true})
+	if err := dec.Decode(&in); !errors.Is(err, ErrIncompatibleType) {
+		t.Fatalf("expected ErrIncompatibleType, got %v", err)
+	}
+	buf.Reset()
+	g.Head = nil
+	if err := enc.Encode(g); err != nil {
+		t.Fatal("Encode failed", err)
+	}
+	if err := dec.Decode(&in); err != nil {
+		t.Fatal("Decode failed", err)
+	}
+	if in.Shared.Name != "c" || in.Shared.Next.Next.Next.Name != "c" || in.Nil != nil {
+		t.Fatalf("Encode/Decode missmatch: %v", in)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"reflect"
+	"sync"
+)
+
+// registry holds concrete types registered for encoding as interface values.
+var registry = struct {
+	sync.RWMutex
+	names map[string]reflect.Type
+	types map[reflect.Type]string
+}{
+	names: make(map[string]reflect.Type),
+	types: make(map[reflect.Type]string),
+}
+
+// Register records a concrete type of value under name so that values of
+// that type can be written and read when held in an interface.
+//
+// Only types that will be held in interface values need to be registered.
+// The same type must be registered under the same name by both the writing
+// and the reading side. Register panics if name is empty, if the type is
+// already registered under a different name or if name is already used by
+// a different type. It is intended to be called from init functions.
+func Register(name string, value interface{}) {
+	if name == "" {
+		panic("binaryex: attempt to register empty name")
+	}
+	t := reflect.TypeOf(value)
+	if t == nil {
+		panic("binaryex: attempt to register nil value")
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if rt, ok := registry.names[name]; ok && rt != t {
+		panic("binaryex: registering duplicate types for " + name)
+	}
+	if rn, ok := registry.types[t]; ok && rn != name {
+		panic("binaryex: registering duplicate names for " + t.String())
+	}
+	registry.names[name] = t
+	registry.types[t] = name
+}
+
+// registeredName returns the name type t was registered under.
+func registeredName(t reflect.Type) (name string, ok bool) {
+	registry.RLock()
+	name, ok = registry.types[t]
+	registry.RUnlock()
+	return
+}
+
+// registeredType returns the type registered under name.
+func registeredType(name string) (t reflect.Type, ok bool) {
+	registry.RLock()
+	t, ok = registry.names[name]
+	registry.RUnlock()
+	return
+}
+
+// encInterface writes an interface value as an id of its' concrete type
+// followed by the concrete value.
+//
+// Ids are assigned to concrete types in order of their first appearance
+// in the stream starting from 1 and the first appearance of an id is
+// followed by the name the type was registered under and, in a self
+// describing stream, by the type descriptor. A nil interface is written as
+// id 0.
+func encInterface(e *Encoder, v reflect.Value) (err error) {
+	if v.IsNil() {
+		return e.writeUvarint(0)
+	}
+	ev := v.Elem()
+	t := ev.Type()
+	if id, ok := e.types[t]; ok {
+		if err = e.writeUvarint(id); err != nil {
+			return
+		}
+		if err = encPlanFor(t)(e, ev); err != nil {
+			return e.pathError(err, typeSeg(t))
+		}
+		return
+	}
+	name, ok := registeredName(t)
+	if !ok {
+		return ErrUnregisteredType
+	}
+	if e.types == nil {
+		e.types = make(map[reflect.Type]uint64)
+	}
+	id := uint64(len(e.types) + 1)
+	e.types[t] = id
+	if err = e.writeUvarint(id); err != nil {
+		return
+	}
+	if err = e.writeString(name); err != nil {
+		return
+	}
+	if e.opts.SelfDescribing {
+		if err = e.writeWireType(t, fieldTag{}); err != nil {
+			return
+		}
+	}
+	if err = encPlanFor(t)(e, ev); err != nil {
+		return e.pathError(err, typeSeg(t))
+	}
+	return
+}
+
+// decInterface reads an interface value written by encInterface.
+func decInterface(d *Decoder, v reflect.Value) (err error) {
+	if err = d.enter(); err != nil {
+		return
+	}
+	id, err := d.readUvarint()
+	if err != nil {
+		return
+	}
+	if id == 0 {
+		v.Set(reflect.Zero(v.Type()))
+		d.leave()
+		return
+	}
+	var t reflect.Type
+	switch {
+	case id <= uint64(len(d.types)):
+		t = d.types[id-1]
+	case id == uint64(len(d.types)+1):
+		var name string
+		if name, err = d.readString(); err != nil {
+			return
+		}
+		var ok bool
+		if t, ok = registeredType(name); !ok {
+			return ErrUnregisteredType
+		}
+		d.types = append(d.types, t)
+	default:
+		return ErrUnexpected
+	}
+	if !t.AssignableTo(v.Type()) {
+		return ErrUnexpected
+	}
+	ev := reflect.New(t).Elem()
+	if err = decPlanFor(t)(d, ev); err != nil {
+		return d.pathError(err, typeSeg(t))
+	}
+	v.Set(ev)
+	d.leave()
+	return
+}
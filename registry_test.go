@@ -0,0 +1,84 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type Shape interface {
+	Area() float64
+}
+
+type Rect struct {
+	W, H float64
+}
+
+func (r Rect) Area() float64 { return r.W * r.H }
+
+type Circle struct {
+	R float64
+}
+
+func (c *Circle) Area() float64 { return 3 * c.R * c.R }
+
+type Unregistered struct{}
+
+func (Unregistered) Area() float64 { return 0 }
+
+type InterfaceTypes struct {
+	Shape     Shape
+	Shapes    []Shape
+	NilShape  Shape
+	Any       interface{}
+	AnyString interface{}
+}
+
+func init() {
+	Register("binaryex.Rect", Rect{})
+	Register("binaryex.Circle", &Circle{})
+	Register("int", 0)
+	Register("string", "")
+}
+
+func TestInterface(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	out := InterfaceTypes{
+		Shape:     Rect{2, 3},
+		Shapes:    []Shape{&Circle{1}, Rect{1, 1}, &Circle{2}, nil},
+		Any:       42,
+		AnyString: "any",
+	}
+	if err := Write(buf, out); err != nil {
+		t.Fatal("Write interface failed", err)
+	}
+	in := InterfaceTypes{NilShape: Rect{}}
+	if err := Read(buf, &in); err != nil {
+		t.Fatal("Read interface failed", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("Read/Write interface missmatch: in\n%#v, out:\n%#v\n", in, out)
+	}
+}
+
+func TestInterfaceUnregistered(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	out := InterfaceTypes{Shape: Unregistered{}}
+	if err := Write(buf, out); !errors.Is(err, ErrUnregisteredType) {
+		t.Fatalf("expected ErrUnregisteredType, got %v", err)
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate Register did not panic")
+		}
+	}()
+	Register("binaryex.Rect", &Circle{})
+}
@@ -0,0 +1,1149 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+)
+
+// Self describing streams
+//
+// An Encoder with SelfDescribing option set first writes a stream header
+// consisting of headerMagic, headerVersion and an UVarInt of header flags
+// that record options of the Encoder that affect the wire format.
+//
+// Every value is then preceded by a wire type descriptor. A descriptor is an
+// UVarInt code which is either one of the scalar wire types below or, if it
+// is wtFirstID or greater, a reference to a composite type defined earlier
+// in the stream. Composite types are assigned ids in order of their first
+// appearance and the first reference to a type is followed by its'
+// definition: an UVarInt composite kind followed by kind specific data:
+//
+//	wtArray:  UVarInt length, element descriptor.
+//	wtSlice:  element descriptor.
+//	wtMap:    key descriptor, element descriptor.
+//	wtPtr:    element descriptor.
+//	wtStruct: UVarInt number of fields followed by an UVarInt ordinal,
+//	          a string name, a flags byte and a descriptor for each field.
+//
+// Elements of byte arrays and slices, which are written as raw bytes, are
+// described by the scalar wire type wtByte and elements of packed arrays and
+// slices by one of the fixed width scalar wire types. Ints and uints are
+// described by a scalar wire type specific to the IntEncoding they were
+// written with.
+//
+// Values themselves are encoded the same as in a stream that is not self
+// describing except that the first appearance of a concrete type of an
+// interface value is followed by its' descriptor.
+
+var headerMagic = [2]byte{'B', 'X'}
+
+const headerVersion = 1
+
+// Header flags.
+const (
+	hfNilPreserve = 1 << iota
+	hfWideFloats
+	hfReferences
+)
+
+// Wire types.
+const (
+	wtNil = iota
+	wtBool
+	wtInt
+	wtUint
+	wtFloat64
+	wtComplex128
+	wtString
+	wtBytes
+	wtInterface
+	wtArray
+	wtSlice
+	wtMap
+	wtPtr
+	wtStruct
+
+	// wtByte is an element of an array or a slice of bytes written as a
+	// single byte. It is a scalar type.
+	wtByte
+	// Fixed width little endian scalar types of elements of packed arrays
+	// and slices and of ints written using IntFixed. Fixed width uint8,
+	// float64 and complex128 values are described by wtByte, wtFloat64 and
+	// wtComplex128. wtFloat32 and wtComplex64 also describe float32 and
+	// complex64 values.
+	wtInt8
+	wtInt16
+	wtInt32
+	wtInt64
+	wtUint16
+	wtUint32
+	wtUint64
+	wtFloat32
+	wtComplex64
+	// wtVarint is an int written as an UVarInt of its' two's complement.
+	wtVarint
+	// Fixed width big endian scalar types. int8 and uint8 values are
+	// described by wtInt8 and wtByte.
+	wtInt16BE
+	wtInt32BE
+	wtInt64BE
+	wtUint16BE
+	wtUint32BE
+	wtUint64BE
+
+	// wtFirstID is the code of the first composite type defined in a stream.
+	wtFirstID = 64
+)
+
+// Struct field flags.
+const (
+	sfOmitEmpty = 1 << iota
+	// sfPresence marks an embedded pointer written with a presence marker.
+	sfPresence
+)
+
+// wireType is a wire type descriptor read from a stream.
+type wireType struct {
+	kind   uint64
+	len    int
+	key    *wireType
+	elem   *wireType
+	fields []wireField
+	omit   int
+}
+
+// wireField is a struct field descriptor read from a stream.
+type wireField struct {
+	ordinal  int
+	name     string
+	bit      int
+	presence bool
+	wt       *wireType
+}
+
+// wireIface is a concrete type of an interface value read from a self
+// describing stream. typ is nil if the type is not registered.
+type wireIface struct {
+	typ reflect.Type
+	wt  *wireType
+}
+
+// scalarTypes holds descriptors of scalar wire types.
+var scalarTypes = func() (a [wtUint64BE + 1]*wireType) {
+	for i := range a {
+		a[i] = &wireType{kind: uint64(i)}
+	}
+	return
+}()
+
+// writeHeader writes the stream header if it was not yet written.
+func (e *Encoder) writeHeader() (err error) {
+	if e.started {
+		return nil
+	}
+	e.started = true
+	if err = e.write(headerMagic[:]); err != nil {
+		return
+	}
+	var flags uint64
+	if e.opts.NilMode == NilPreserve {
+		flags |= hfNilPreserve
+	}
+	if e.opts.WideFloats {
+		flags |= hfWideFloats
+	}
+	if e.opts.References {
+		flags |= hfReferences
+	}
+	e.buf[0] = headerVersion
+	if err = e.write(e.buf[:1]); err != nil {
+		return
+	}
+	return e.writeUvarint(flags)
+}
+
+// readHeader reads the stream header if it was not yet read and adopts
+// options recorded in it.
+func (d *Decoder) readHeader() (err error) {
+	if d.started {
+		return nil
+	}
+	d.started = true
+	if err = d.readFull(d.buf[:3]); err != nil {
+		return
+	}
+	if d.buf[0] != headerMagic[0] || d.buf[1] != headerMagic[1] ||
+		d.buf[2] != headerVersion {
+		return ErrInvalidHeader
+	}
+	flags, err := d.readUvarint()
+	if err != nil {
+		return
+	}
+	if flags&^(hfNilPreserve|hfWideFloats|hfReferences) != 0 {
+		return ErrInvalidHeader
+	}
+	d.opts.NilMode = NilLegacy
+	if flags&hfNilPreserve != 0 {
+		d.opts.NilMode = NilPreserve
+	}
+	d.opts.WideFloats = flags&hfWideFloats != 0
+	d.opts.References = flags&hfReferences != 0
+	return
+}
+
+// isScalarWire returns true if wire type code k is a scalar type.
+func isScalarWire(k uint64) bool {
+	return k <= wtInterface || k >= wtByte && k <= wtUint64BE
+}
+
+// fixedWireType returns the scalar wire type of a number of kind k written
+// as a fixed width number.
+func fixedWireType(k reflect.Kind) uint64 {
+	switch k {
+	case reflect.Int8:
+		return wtInt8
+	case reflect.Int16:
+		return wtInt16
+	case reflect.Int32:
+		return wtInt32
+	case reflect.Int, reflect.Int64:
+		return wtInt64
+	case reflect.Uint8:
+		return wtByte
+	case reflect.Uint16:
+		return wtUint16
+	case reflect.Uint32:
+		return wtUint32
+	case reflect.Uint, reflect.Uint64:
+		return wtUint64
+	case reflect.Float32:
+		return wtFloat32
+	case reflect.Float64:
+		return wtFloat64
+	case reflect.Complex64:
+		return wtComplex64
+	case reflect.Complex128:
+		return wtComplex128
+	}
+	return wtNil
+}
+
+// intWireType returns the scalar wire type of an int or an uint of kind k
+// written using encoding enc.
+func intWireType(k reflect.Kind, enc IntEncoding) uint64 {
+	signed := k >= reflect.Int && k <= reflect.Int64
+	switch enc {
+	case IntVarint:
+		if signed {
+			return wtVarint
+		}
+	case IntFixed:
+		return fixedWireType(k)
+	case IntFixedBE:
+		switch k {
+		case reflect.Int16:
+			return wtInt16BE
+		case reflect.Int32:
+			return wtInt32BE
+		case reflect.Int, reflect.Int64:
+			return wtInt64BE
+		case reflect.Uint16:
+			return wtUint16BE
+		case reflect.Uint32:
+			return wtUint32BE
+		case reflect.Uint, reflect.Uint64:
+			return wtUint64BE
+		}
+		return fixedWireType(k)
+	default:
+		if signed {
+			return wtInt
+		}
+	}
+	return wtUint
+}
+
+// wireFixedSize returns the size of a value of a fixed width scalar wire
+// type k or 0 if k is not fixed width.
+func wireFixedSize(k uint64) int {
+	switch k {
+	case wtByte, wtInt8:
+		return 1
+	case wtInt16, wtUint16, wtInt16BE, wtUint16BE:
+		return 2
+	case wtInt32, wtUint32, wtFloat32, wtInt32BE, wtUint32BE:
+		return 4
+	case wtInt64, wtUint64, wtFloat64, wtComplex64, wtInt64BE, wtUint64BE:
+		return 8
+	case wtComplex128:
+		return 16
+	}
+	return 0
+}
+
+// wireDef is a key of a composite type defined in a stream.
+type wireDef struct {
+	t      reflect.Type
+	packed bool
+	ints   IntEncoding
+}
+
+// writeWireType writes a wire type descriptor of type t. ft holds options
+// of the struct field tag t is written with, if any.
+func (e *Encoder) writeWireType(t reflect.Type, ft fieldTag) (err error) {
+	packed := isPackable(t) && (ft.packed || e.opts.Packed && !ft.intsSet)
+	ints := e.opts.IntEncoding
+	if ft.intsSet {
+		ints = ft.ints
+	}
+	var kind uint64
+	switch t.Kind() {
+	case reflect.Ptr:
+		kind = wtPtr
+	case reflect.Interface:
+		return e.writeUvarint(wtInterface)
+	}
+	if kind == 0 {
+		if e.codecFor(t) != nil ||
+			bytesMarshaler(e.opts.Marshalers, marshalers(t, true)) != 0 {
+			return e.writeUvarint(wtBytes)
+		}
+		switch t.Kind() {
+		case reflect.Bool:
+			return e.writeUvarint(wtBool)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+			reflect.Uint64:
+			return e.writeUvarint(intWireType(t.Kind(), ints))
+		case reflect.Float32:
+			if e.opts.WideFloats {
+				return e.writeUvarint(wtFloat64)
+			}
+			return e.writeUvarint(wtFloat32)
+		case reflect.Float64:
+			return e.writeUvarint(wtFloat64)
+		case reflect.Complex64:
+			if e.opts.WideFloats {
+				return e.writeUvarint(wtComplex128)
+			}
+			return e.writeUvarint(wtComplex64)
+		case reflect.Complex128:
+			return e.writeUvarint(wtComplex128)
+		case reflect.String:
+			return e.writeUvarint(wtString)
+		case reflect.Array:
+			kind = wtArray
+		case reflect.Slice:
+			kind = wtSlice
+		case reflect.Map:
+			kind = wtMap
+		case reflect.Struct:
+			kind = wtStruct
+		default:
+			return ErrUnsupportedValue
+		}
+	}
+	// Composite types are referenced by id once defined.
+	def := wireDef{t: t, packed: packed}
+	if !packed && isIntSequence(t) {
+		def.ints = ints
+	}
+	if id, ok := e.defs[def]; ok {
+		return e.writeUvarint(id)
+	}
+	var fields []structField
+	if kind == wtStruct {
+		if fields, _, err = structFields(t); err != nil {
+			return
+		}
+	}
+	if e.defs == nil {
+		e.defs = make(map[wireDef]uint64)
+	}
+	id := uint64(wtFirstID + len(e.defs))
+	e.defs[def] = id
+	if err = e.writeUvarint(id); err != nil {
+		return
+	}
+	if err = e.writeUvarint(kind); err != nil {
+		return
+	}
+	switch kind {
+	case wtArray:
+		if err = e.writeUvarint(uint64(t.Len())); err != nil {
+			return
+		}
+		fallthrough
+	case wtSlice:
+		if isByteType(t.Elem()) {
+			return e.writeUvarint(wtByte)
+		}
+		if packed {
+			return e.writeUvarint(fixedWireType(t.Elem().Kind()))
+		}
+		var et fieldTag
+		if ft.intsSet {
+			et.ints, et.intsSet = ft.ints, true
+		}
+		return e.writeWireType(t.Elem(), et)
+	case wtPtr:
+		return e.writeWireType(t.Elem(), fieldTag{})
+	case wtMap:
+		if err = e.writeWireType(t.Key(), fieldTag{}); err != nil {
+			return
+		}
+		return e.writeWireType(t.Elem(), fieldTag{})
+	}
+	if err = e.writeUvarint(uint64(len(fields))); err != nil {
+		return
+	}
+	for _, f := range fields {
+		if err = e.writeUvarint(uint64(f.tag.ordinal)); err != nil {
+			return
+		}
+		if err = e.writeString(f.name); err != nil {
+			return
+		}
+		var flags byte
+		if f.tag.omitEmpty {
+			flags |= sfOmitEmpty
+		}
+		if f.embeddedPtr {
+			flags |= sfPresence
+		}
+		e.buf[0] = flags
+		if err = e.write(e.buf[:1]); err != nil {
+			return
+		}
+		if err = e.writeWireType(f.typ, f.tag); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// readWireType reads a wire type descriptor.
+func (d *Decoder) readWireType() (wt *wireType, err error) {
+	code, err := d.readUvarint()
+	if err != nil {
+		return
+	}
+	if code < wtFirstID {
+		if !isScalarWire(code) {
+			return nil, ErrUnexpected
+		}
+		return scalarTypes[code], nil
+	}
+	switch id := code - wtFirstID; {
+	case id < uint64(len(d.defs)):
+		return d.defs[id], nil
+	case id > uint64(len(d.defs)):
+		return nil, ErrUnexpected
+	}
+	if err = d.enter(); err != nil {
+		return
+	}
+	kind, err := d.readUvarint()
+	if err != nil {
+		return
+	}
+	wt = &wireType{kind: kind}
+	d.defs = append(d.defs, wt)
+	switch kind {
+	case wtArray:
+		var l uint64
+		if l, err = d.readUvarint(); err != nil {
+			return
+		}
+		if uint64(int(l)) != l || int(l) < 0 {
+			return nil, ErrUnexpected
+		}
+		if d.opts.MaxSliceLen > 0 && int(l) > d.opts.MaxSliceLen {
+			return nil, ErrLimitExceeded
+		}
+		wt.len = int(l)
+		wt.elem, err = d.readWireType()
+	case wtSlice, wtPtr:
+		wt.elem, err = d.readWireType()
+	case wtMap:
+		if wt.key, err = d.readWireType(); err != nil {
+			return
+		}
+		wt.elem, err = d.readWireType()
+	case wtStruct:
+		err = d.readWireStruct(wt)
+	default:
+		err = ErrUnexpected
+	}
+	if err == nil {
+		d.leave()
+	}
+	return
+}
+
+// readWireStruct reads fields of a struct definition into wt.
+func (d *Decoder) readWireStruct(wt *wireType) (err error) {
+	n, err := d.readUvarint()
+	if err != nil {
+		return
+	}
+	for i := uint64(0); i < n; i++ {
+		var (
+			f       wireField
+			ordinal uint64
+			flags   byte
+		)
+		if ordinal, err = d.readUvarint(); err != nil {
+			return
+		}
+		if uint64(int(ordinal)) != ordinal || int(ordinal) < 0 {
+			return ErrUnexpected
+		}
+		f.ordinal = int(ordinal)
+		if f.name, err = d.readString(); err != nil {
+			return
+		}
+		if flags, err = d.r.ReadByte(); err != nil {
+			return
+		}
+		f.bit = -1
+		if flags&sfOmitEmpty != 0 {
+			f.bit = wt.omit
+			wt.omit++
+		}
+		f.presence = flags&sfPresence != 0
+		if f.wt, err = d.readWireType(); err != nil {
+			return
+		}
+		wt.fields = append(wt.fields, f)
+	}
+	return
+}
+
+// wireMatchKey is a key of a cached match of a wire struct to a struct type.
+type wireMatchKey struct {
+	wt *wireType
+	t  reflect.Type
+}
+
+// wireMatch maps fields of a wire struct to fields of a struct type.
+type wireMatch struct {
+	// fields holds a struct field for each wire field or nil if the wire
+	// field does not exist in the struct.
+	fields []*structField
+	// missing holds index sequences of struct fields not found in wire
+	// struct.
+	missing [][]int
+}
+
+// matchStruct returns a match of wire struct wt to struct type t. Fields
+// with an ordinal are matched by ordinal and others by name.
+func (d *Decoder) matchStruct(wt *wireType, t reflect.Type) (m *wireMatch, err error) {
+	key := wireMatchKey{wt, t}
+	if m = d.matches[key]; m != nil {
+		return
+	}
+	sf, _, err := structFields(t)
+	if err != nil {
+		return
+	}
+	m = &wireMatch{fields: make([]*structField, len(wt.fields))}
+	found := make([]bool, len(sf))
+	for i, wf := range wt.fields {
+		for j, f := range sf {
+			if found[j] || f.tag.ordinal != wf.ordinal ||
+				(wf.ordinal == 0 && f.name != wf.name) {
+				continue
+			}
+			m.fields[i] = &sf[j]
+			found[j] = true
+			break
+		}
+	}
+	for j, f := range sf {
+		if !found[j] {
+			m.missing = append(m.missing, f.index)
+		}
+	}
+	if d.matches == nil {
+		d.matches = make(map[wireMatchKey]*wireMatch)
+	}
+	d.matches[key] = m
+	return
+}
+
+// decodeWire reads a value of wire type wt into v.
+func (d *Decoder) decodeWire(wt *wireType, v reflect.Value) (err error) {
+	if isScalarWire(wt.kind) && wt.kind != wtInterface {
+		return d.decodeWireValue(wt, v)
+	}
+	if err = d.enterWire(); err != nil {
+		return
+	}
+	if err = d.decodeWireValue(wt, v); err == nil {
+		d.leave()
+	}
+	return
+}
+
+// decodeWireValue reads a value of wire type wt into v.
+func (d *Decoder) decodeWireValue(wt *wireType, v reflect.Value) (err error) {
+	t := v.Type()
+	if wt.kind == wtNil {
+		v.Set(reflect.Zero(t))
+		return
+	}
+	// Pointers on either side are dereferenced.
+	if wt.kind == wtPtr {
+		if d.opts.References {
+			return d.decodeWireRef(wt.elem, v)
+		}
+		if d.opts.NilMode == NilPreserve {
+			var present bool
+			if present, err = d.readBool(); err != nil {
+				return
+			}
+			if !present {
+				v.Set(reflect.Zero(t))
+				return
+			}
+		}
+		wt = wt.elem
+	}
+	if t.Kind() == reflect.Ptr {
+		pv := reflect.New(t.Elem())
+		if err = d.decodeWire(wt, pv.Elem()); err != nil {
+			return
+		}
+		v.Set(pv)
+		return
+	}
+	if wt.kind == wtInterface {
+		return d.decodeWireInterface(v)
+	}
+	if dec := d.codecFor(t); dec != nil {
+		if wt.kind != wtBytes {
+			return ErrIncompatibleType
+		}
+		return d.decodeCodec(dec, v)
+	}
+	if k := bytesMarshaler(d.opts.Marshalers, marshalers(t, false)); k != 0 || wt.kind == wtBytes {
+		if k == 0 || wt.kind != wtBytes {
+			return ErrIncompatibleType
+		}
+		return decMarshaler(d, v, k)
+	}
+	switch k := t.Kind(); wt.kind {
+	case wtBool:
+		if k == reflect.Bool {
+			return decBool(d, v)
+		}
+	case wtInt:
+		switch k {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return decWireInt(d, v)
+		}
+	case wtUint:
+		switch k {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+			reflect.Uint64:
+			return decWireUint(d, v)
+		}
+	case wtString:
+		if k == reflect.String {
+			return decString(d, v)
+		}
+	case wtVarint:
+		switch k {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return decWireVarint(d, v)
+		}
+	case wtInt8, wtInt16, wtInt32, wtInt64, wtInt16BE, wtInt32BE, wtInt64BE:
+		switch k {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return d.decodeWireFixed(wt.kind, v)
+		}
+	case wtByte, wtUint16, wtUint32, wtUint64, wtUint16BE, wtUint32BE,
+		wtUint64BE:
+		switch k {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+			reflect.Uint64:
+			return d.decodeWireFixed(wt.kind, v)
+		}
+	case wtFloat32, wtFloat64:
+		if k == reflect.Float32 || k == reflect.Float64 {
+			return d.decodeWireFixed(wt.kind, v)
+		}
+	case wtComplex64, wtComplex128:
+		if k == reflect.Complex64 || k == reflect.Complex128 {
+			return d.decodeWireFixed(wt.kind, v)
+		}
+	case wtArray, wtSlice:
+		if k != reflect.Array && k != reflect.Slice {
+			break
+		}
+		l := wt.len
+		if wt.kind == wtSlice {
+			if l, err = d.readNilLen(d.opts.MaxSliceLen); err != nil {
+				return
+			}
+			if l < 0 {
+				v.Set(reflect.Zero(t))
+				return
+			}
+		}
+		if isFixedType(t.Elem()) && wt.elem.kind == fixedWireType(t.Elem().Kind()) {
+			return d.decodeWirePacked(l, v)
+		}
+		if k == reflect.Slice {
+			return d.decodeWireSlice(wt, l, v)
+		}
+		return d.decodeWireArray(wt, l, v)
+	case wtMap:
+		if k == reflect.Map {
+			return d.decodeWireMap(wt, v)
+		}
+	case wtStruct:
+		if k == reflect.Struct {
+			return d.decodeWireStruct(wt, v)
+		}
+	}
+	return ErrIncompatibleType
+}
+
+// decodeWireSlice reads l elements of array or slice wire type wt into
+// slice v. The slice is grown as elements are read.
+func (d *Decoder) decodeWireSlice(wt *wireType, l int, v reflect.Value) (err error) {
+	t := v.Type()
+	n := preallocLen(l, t.Elem().Size())
+	s := reflect.MakeSlice(t, n, n)
+	ec := d.elemCounter(d.opts.MaxSliceLen)
+	for i := 0; i < l; i++ {
+		s = growSlice(s, i, l)
+		if err = d.decodeWire(wt.elem, s.Index(i)); err == nil {
+			err = ec.next(d)
+		}
+		if err != nil {
+			return d.pathError(err, indexSeg(i))
+		}
+	}
+	v.Set(s)
+	return
+}
+
+// Plans reading ints and uints of wire types wtInt, wtUint and wtVarint
+// regardless of IntEncoding option.
+var (
+	decWireInt    = newIntDecoder(reflect.TypeOf(int64(0)), IntZigZag)
+	decWireUint   = newIntDecoder(reflect.TypeOf(uint64(0)), IntZigZag)
+	decWireVarint = newIntDecoder(reflect.TypeOf(int64(0)), IntVarint)
+)
+
+// decodeWireFixed reads a number of fixed width wire type k into v.
+func (d *Decoder) decodeWireFixed(k uint64, v reflect.Value) (err error) {
+	p := d.buf[:wireFixedSize(k)]
+	if err = d.readFull(p); err != nil {
+		return
+	}
+	le, be := binary.LittleEndian, binary.BigEndian
+	switch k {
+	case wtInt16BE:
+		v.SetInt(int64(int16(be.Uint16(p))))
+	case wtInt32BE:
+		v.SetInt(int64(int32(be.Uint32(p))))
+	case wtInt64BE:
+		v.SetInt(int64(be.Uint64(p)))
+	case wtUint16BE:
+		v.SetUint(uint64(be.Uint16(p)))
+	case wtUint32BE:
+		v.SetUint(uint64(be.Uint32(p)))
+	case wtUint64BE:
+		v.SetUint(be.Uint64(p))
+	case wtInt8:
+		v.SetInt(int64(int8(p[0])))
+	case wtInt16:
+		v.SetInt(int64(int16(le.Uint16(p))))
+	case wtInt32:
+		v.SetInt(int64(int32(le.Uint32(p))))
+	case wtInt64:
+		v.SetInt(int64(le.Uint64(p)))
+	case wtByte:
+		v.SetUint(uint64(p[0]))
+	case wtUint16:
+		v.SetUint(uint64(le.Uint16(p)))
+	case wtUint32:
+		v.SetUint(uint64(le.Uint32(p)))
+	case wtUint64:
+		v.SetUint(le.Uint64(p))
+	case wtFloat32:
+		v.SetFloat(float64(math.Float32frombits(le.Uint32(p))))
+	case wtFloat64:
+		v.SetFloat(math.Float64frombits(le.Uint64(p)))
+	case wtComplex64:
+		v.SetComplex(complex(
+			float64(math.Float32frombits(le.Uint32(p))),
+			float64(math.Float32frombits(le.Uint32(p[4:]))),
+		))
+	case wtComplex128:
+		v.SetComplex(complex(
+			math.Float64frombits(le.Uint64(p)),
+			math.Float64frombits(le.Uint64(p[8:])),
+		))
+	}
+	return
+}
+
+// decodeWirePacked reads l fixed width numbers of the same width as
+// elements of slice or array v into v in bulk. Numbers that do not fit into
+// an array are skipped and elements of the array that were not read are set
+// to zero.
+func (d *Decoder) decodeWirePacked(l int, v reflect.Value) (err error) {
+	et := v.Type().Elem()
+	if v.Kind() == reflect.Slice {
+		if !isByteType(et) {
+			return d.readPackedSlice(v, l)
+		}
+		var p []byte
+		if p, err = d.readAlias(l); err == nil {
+			v.SetBytes(p)
+		}
+		return
+	}
+	n := l
+	if n > v.Len() {
+		n = v.Len()
+	}
+	if isByteType(et) {
+		err = d.readFull(v.Slice(0, n).Bytes())
+	} else {
+		err = d.readPacked(v, 0, n)
+	}
+	if err != nil {
+		return
+	}
+	if l > n {
+		return d.discardElems(l-n, fixedSize(et.Kind()))
+	}
+	for i := n; i < v.Len(); i++ {
+		v.Index(i).Set(reflect.Zero(et))
+	}
+	return
+}
+
+// decodeWireArray reads l elements of array or slice wire type wt into
+// array v. Elements that do not fit into v are skipped and elements of v
+// that were not read are set to zero.
+func (d *Decoder) decodeWireArray(wt *wireType, l int, v reflect.Value) (err error) {
+	ec := d.elemCounter(d.opts.MaxSliceLen)
+	for i := 0; i < l; i++ {
+		if i >= v.Len() {
+			err = d.skipWire(wt.elem)
+		} else {
+			err = d.decodeWire(wt.elem, v.Index(i))
+		}
+		if err == nil {
+			err = ec.next(d)
+		}
+		if err != nil {
+			return d.pathError(err, indexSeg(i))
+		}
+	}
+	for i := l; i < v.Len(); i++ {
+		v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+	}
+	return
+}
+
+// decodeWireMap reads a map of wire type wt into map v.
+func (d *Decoder) decodeWireMap(wt *wireType, v reflect.Value) (err error) {
+	l, err := d.readNilLen(d.opts.MaxMapLen)
+	if err != nil {
+		return
+	}
+	t := v.Type()
+	if l < 0 {
+		v.Set(reflect.Zero(t))
+		return
+	}
+	v.Set(reflect.MakeMap(t))
+	var kc *keyCheck
+	if d.opts.Canonical {
+		kc = &keyCheck{nm: d.opts.NilMode}
+	}
+	ec := d.elemCounter(d.opts.MaxMapLen)
+	for i := 0; i < l; i++ {
+		kv := reflect.New(t.Key()).Elem()
+		if err = d.decodeWire(wt.key, kv); err != nil {
+			return d.pathError(err, mapKeySeg(i))
+		}
+		if kc != nil {
+			if err = kc.next(kv); err != nil {
+				return d.pathError(err, keySeg(kv))
+			}
+		}
+		ev := reflect.New(t.Elem()).Elem()
+		if err = d.decodeWire(wt.elem, ev); err == nil {
+			err = ec.next(d)
+		}
+		if err != nil {
+			return d.pathError(err, keySeg(kv))
+		}
+		v.SetMapIndex(kv, ev)
+	}
+	return
+}
+
+// decodeWireStruct reads a struct of wire type wt into struct v. Fields that
+// do not exist in v are skipped and fields of v that were not read are set
+// to zero.
+func (d *Decoder) decodeWireStruct(wt *wireType, v reflect.Value) (err error) {
+	m, err := d.matchStruct(wt, v.Type())
+	if err != nil {
+		return
+	}
+	var bm []byte
+	if wt.omit > 0 {
+		bm = make([]byte, (wt.omit+7)/8)
+		if err = d.readFull(bm); err != nil {
+			return
+		}
+	}
+	for i, wf := range wt.fields {
+		sf := m.fields[i]
+		name := wf.name
+		if sf != nil {
+			name = sf.name
+		}
+		present := wf.bit < 0 || bm[wf.bit/8]&(1<<uint(wf.bit%8)) != 0
+		if present {
+			if present, err = d.readPresence(wf.presence); err != nil {
+				return d.pathError(err, fieldSeg(name))
+			}
+		}
+		if !present {
+			if sf != nil {
+				fv := fieldValue(v, sf.index)
+				fv.Set(reflect.Zero(fv.Type()))
+			}
+			continue
+		}
+		if sf == nil {
+			err = d.skipWire(wf.wt)
+		} else {
+			err = d.decodeWire(wf.wt, fieldValue(v, sf.index))
+		}
+		if err != nil {
+			return d.pathError(err, fieldSeg(name))
+		}
+	}
+	for _, idx := range m.missing {
+		fv := fieldValue(v, idx)
+		fv.Set(reflect.Zero(fv.Type()))
+	}
+	return
+}
+
+// readWireIface reads the concrete type of an interface value and returns
+// nil if the interface is nil.
+func (d *Decoder) readWireIface() (wi *wireIface, err error) {
+	id, err := d.readUvarint()
+	if err != nil || id == 0 {
+		return
+	}
+	switch {
+	case id <= uint64(len(d.ifaces)):
+		return &d.ifaces[id-1], nil
+	case id > uint64(len(d.ifaces)+1):
+		return nil, ErrUnexpected
+	}
+	name, err := d.readString()
+	if err != nil {
+		return
+	}
+	t, _ := registeredType(name)
+	wt, err := d.readWireType()
+	if err != nil {
+		return
+	}
+	d.ifaces = append(d.ifaces, wireIface{t, wt})
+	return &d.ifaces[len(d.ifaces)-1], nil
+}
+
+// decodeWireInterface reads an interface value into v.
+func (d *Decoder) decodeWireInterface(v reflect.Value) (err error) {
+	t := v.Type()
+	if t.Kind() != reflect.Interface {
+		return ErrIncompatibleType
+	}
+	wi, err := d.readWireIface()
+	if err != nil {
+		return
+	}
+	if wi == nil {
+		v.Set(reflect.Zero(t))
+		return
+	}
+	if wi.typ == nil {
+		return ErrUnregisteredType
+	}
+	if !wi.typ.AssignableTo(t) {
+		return ErrIncompatibleType
+	}
+	ev := reflect.New(wi.typ).Elem()
+	if err = d.decodeWire(wi.wt, ev); err != nil {
+		return d.pathError(err, typeSeg(wi.typ))
+	}
+	v.Set(ev)
+	return
+}
+
+// discard reads and discards n bytes.
+func (d *Decoder) discard(n int64) (err error) {
+	if n < 0 {
+		return ErrUnexpected
+	}
+	if _, err = io.CopyN(ioutil.Discard, &d.r, n); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+// discardElems reads and discards l elements of size bytes each.
+func (d *Decoder) discardElems(l, size int) error {
+	if l < 0 || size > 0 && int64(l) > math.MaxInt64/int64(size) {
+		return ErrUnexpected
+	}
+	return d.discard(int64(l) * int64(size))
+}
+
+// skipWire reads and discards a value of wire type wt.
+func (d *Decoder) skipWire(wt *wireType) (err error) {
+	if isScalarWire(wt.kind) && wt.kind != wtInterface {
+		return d.skipWireValue(wt)
+	}
+	if err = d.enterWire(); err != nil {
+		return
+	}
+	if err = d.skipWireValue(wt); err == nil {
+		d.leave()
+	}
+	return
+}
+
+// skipWireValue reads and discards a value of wire type wt.
+func (d *Decoder) skipWireValue(wt *wireType) (err error) {
+	switch wt.kind {
+	case wtNil:
+	case wtBool:
+		_, err = d.readBool()
+	case wtInt:
+		_, err = d.readVarint()
+	case wtUint, wtVarint:
+		_, err = d.readUvarint()
+	case wtFloat64:
+		err = d.discard(8)
+	case wtComplex128:
+		err = d.discard(16)
+	case wtString:
+		var l int
+		if l, err = d.readLen(d.opts.MaxStringLen); err == nil {
+			err = d.discard(int64(l))
+		}
+	case wtBytes:
+		var l int
+		if l, err = d.readLen(d.opts.MaxSliceLen); err == nil {
+			err = d.discard(int64(l))
+		}
+	case wtInterface:
+		var wi *wireIface
+		if wi, err = d.readWireIface(); err == nil && wi != nil {
+			err = d.skipWire(wi.wt)
+		}
+	case wtArray:
+		if n := wireFixedSize(wt.elem.kind); n > 0 {
+			return d.discardElems(wt.len, n)
+		}
+		ec := d.elemCounter(d.opts.MaxSliceLen)
+		for i := 0; i < wt.len && err == nil; i++ {
+			if err = d.skipWire(wt.elem); err == nil {
+				err = ec.next(d)
+			}
+		}
+	case wtSlice:
+		var l int
+		if l, err = d.readNilLen(d.opts.MaxSliceLen); err != nil {
+			return
+		}
+		if n := wireFixedSize(wt.elem.kind); n > 0 && l > 0 {
+			return d.discardElems(l, n)
+		}
+		ec := d.elemCounter(d.opts.MaxSliceLen)
+		for i := 0; i < l && err == nil; i++ {
+			if err = d.skipWire(wt.elem); err == nil {
+				err = ec.next(d)
+			}
+		}
+	case wtMap:
+		var l int
+		if l, err = d.readNilLen(d.opts.MaxMapLen); err != nil {
+			return
+		}
+		ec := d.elemCounter(d.opts.MaxMapLen)
+		for i := 0; i < l && err == nil; i++ {
+			if err = d.skipWire(wt.key); err == nil {
+				err = d.skipWire(wt.elem)
+			}
+			if err == nil {
+				err = ec.next(d)
+			}
+		}
+	case wtPtr:
+		if d.opts.References {
+			return d.skipWireRef(wt.elem)
+		}
+		if d.opts.NilMode == NilPreserve {
+			var present bool
+			if present, err = d.readBool(); err != nil || !present {
+				return
+			}
+		}
+		err = d.skipWire(wt.elem)
+	case wtStruct:
+		var bm []byte
+		if wt.omit > 0 {
+			bm = make([]byte, (wt.omit+7)/8)
+			if err = d.readFull(bm); err != nil {
+				return
+			}
+		}
+		for _, wf := range wt.fields {
+			if wf.bit >= 0 && bm[wf.bit/8]&(1<<uint(wf.bit%8)) == 0 {
+				continue
+			}
+			var present bool
+			if present, err = d.readPresence(wf.presence); err != nil {
+				return
+			}
+			if !present {
+				continue
+			}
+			if err = d.skipWire(wf.wt); err != nil {
+				return
+			}
+		}
+	default:
+		if n := wireFixedSize(wt.kind); n > 0 {
+			return d.discard(int64(n))
+		}
+		err = ErrUnexpected
+	}
+	return
+}
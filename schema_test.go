@@ -0,0 +1,126 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type RecordItem struct {
+	Name  string
+	Price float64
+}
+
+type RecordV1 struct {
+	ID      uint64 `binaryex:"1"`
+	Name    string `binaryex:"2"`
+	Removed []RecordItem
+	Nested  map[string][]RecordItem
+	Any     interface{}
+	Time    time.Time
+	Comment string `binaryex:"3,omitempty"`
+	Flag    bool
+}
+
+type RecordV2 struct {
+	Added   *RecordItem
+	Flag    bool
+	Comment string `binaryex:"3,omitempty"`
+	ID      uint64 `binaryex:"1"`
+	Time    time.Time
+	Name    string `binaryex:"2"`
+}
+
+func TestSelfDescribing(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	out := BaseTypes{}
+	out.init()
+	enc := NewEncoderWithOptions(buf, EncoderOptions{SelfDescribing: true})
+	if err := enc.Encode(out); err != nil {
+		t.Fatal("Encode self describing failed", err)
+	}
+	first := buf.Len()
+	if err := enc.Encode(out); err != nil {
+		t.Fatal("Encode self describing failed", err)
+	}
+	if second := buf.Len() - first; second >= first {
+		t.Fatalf("type descriptor written twice: %d, %d", first, second)
+	}
+	dec := NewDecoderWithOptions(buf, DecoderOptions{SelfDescribing: true})
+	for i := 0; i < 2; i++ {
+		in := BaseTypes{}
+		if err := dec.Decode(&in); err != nil {
+			t.Fatal("Decode self describing failed", err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("Encode/Decode self describing missmatch: in\n%v, out:\n%v\n", in, out)
+		}
+	}
+}
+
+func TestSelfDescribingEvolution(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	out := RecordV1{
+		ID:      42,
+		Name:    "record",
+		Removed: []RecordItem{{"a", 1}, {"b", 2}},
+		Nested:  map[string][]RecordItem{"c": {{"d", 3}}},
+		Any:     &Circle{1},
+		Time:    time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC),
+		Flag:    true,
+	}
+	enc := NewEncoderWithOptions(buf, EncoderOptions{
+		NilMode:        NilPreserve,
+		SelfDescribing: true,
+	})
+	for i := 0; i < 2; i++ {
+		if err := enc.Encode(out); err != nil {
+			t.Fatal("Encode self describing failed", err)
+		}
+	}
+	dec := NewDecoderWithOptions(buf, DecoderOptions{SelfDescribing: true})
+	for i := 0; i < 2; i++ {
+		in := RecordV2{Added: &RecordItem{}, Comment: "stale"}
+		if err := dec.Decode(&in); err != nil {
+			t.Fatal("Decode self describing failed", err)
+		}
+		if in.ID != out.ID || in.Name != out.Name || in.Flag != out.Flag ||
+			!in.Time.Equal(out.Time) || in.Comment != "" || in.Added != nil {
+			t.Fatalf("Encode/Decode self describing missmatch: in\n%v, out:\n%v\n", in, out)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("%d bytes left unread", buf.Len())
+	}
+}
+
+func TestSelfDescribingIncompatible(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoderWithOptions(buf, EncoderOptions{SelfDescribing: true})
+	if err := enc.Encode(RecordItem{"a", 1}); err != nil {
+		t.Fatal("Encode self describing failed", err)
+	}
+	dec := NewDecoderWithOptions(buf, DecoderOptions{SelfDescribing: true})
+	var in struct{ Name int }
+	if err := dec.Decode(&in); !errors.Is(err, ErrIncompatibleType) {
+		t.Fatalf("expected ErrIncompatibleType, got %v", err)
+	}
+}
+
+func TestSelfDescribingInvalidHeader(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	if err := Write(buf, RecordItem{"a", 1}); err != nil {
+		t.Fatal("Write failed", err)
+	}
+	dec := NewDecoderWithOptions(buf, DecoderOptions{SelfDescribing: true})
+	var in RecordItem
+	if err := dec.Decode(&in); !errors.Is(err, ErrInvalidHeader) {
+		t.Fatalf("expected ErrInvalidHeader, got %v", err)
+	}
+}
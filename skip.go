@@ -0,0 +1,451 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Skip reads and discards the next value in the stream which was written as
+// a value of type typ or returns an error if one occured. Only length
+// prefixes, numbers and markers are parsed, strings and raw bytes are
+// discarded unread and no values are allocated.
+//
+// If SelfDescribing option is set the value is skipped as described in the
+// stream and typ is ignored and may be nil, otherwise a nil typ returns an
+// error wrapping ErrInvalidArgument.
+//
+// As with DecodeValue, io.EOF is returned if the stream ends before the
+// value.
+func (d *Decoder) Skip(typ reflect.Type) error {
+	return d.decodeError(d.skipValue(typ), typ)
+}
+
+// skipValue skips a top level value for Skip. Panics are recovered and
+// returned as errors.
+func (d *Decoder) skipValue(typ reflect.Type) (err error) {
+	defer recoverError(&err)
+	if !d.hasReader() || typ == nil && !d.opts.SelfDescribing {
+		return ErrInvalidArgument
+	}
+	d.begin()
+	if !d.opts.SelfDescribing {
+		return skipPlanFor(typ)(d)
+	}
+	if err = d.readHeader(); err != nil {
+		return
+	}
+	var wt *wireType
+	if wt, err = d.readWireType(); err != nil {
+		return
+	}
+	return d.skipWire(wt)
+}
+
+// skipFunc is a compiled skipping plan for a type.
+type skipFunc func(d *Decoder) error
+
+// skipPlans caches compiled skipping plans, map[reflect.Type]skipFunc.
+var skipPlans sync.Map
+
+// skipPlanFor returns a cached skipping plan for type t, compiling it first
+// if required.
+func skipPlanFor(t reflect.Type) skipFunc {
+	if fi, ok := skipPlans.Load(t); ok {
+		return fi.(skipFunc)
+	}
+	// Store an indirect plan first so recursive types resolve to it while
+	// the real plan is being compiled.
+	var (
+		wg sync.WaitGroup
+		f  skipFunc
+	)
+	wg.Add(1)
+	fi, loaded := skipPlans.LoadOrStore(t, skipFunc(func(d *Decoder) error {
+		wg.Wait()
+		return f(d)
+	}))
+	if loaded {
+		return fi.(skipFunc)
+	}
+	f = newSkipFunc(t)
+	wg.Done()
+	skipPlans.Store(t, f)
+	return f
+}
+
+// newSkipFunc compiles a skipping plan for type t that mirrors its'
+// decoding plan. Values of types with codecs can not be skipped.
+func newSkipFunc(t reflect.Type) skipFunc {
+	if _, ok := registeredCodec(t); ok {
+		return func(d *Decoder) error { return ErrUnsupportedValue }
+	}
+	if ms := marshalers(t, false); ms != 0 {
+		refl := newReflectSkipFunc(t)
+		return withSkipCodecs(t, func(d *Decoder) error {
+			if bytesMarshaler(d.opts.Marshalers, ms) != 0 {
+				return skipBytes(d)
+			}
+			return refl(d)
+		})
+	}
+	return withSkipCodecs(t, newReflectSkipFunc(t))
+}
+
+// newReflectSkipFunc compiles a skipping plan for type t that skips values
+// as written without marshaler methods.
+func newReflectSkipFunc(t reflect.Type) skipFunc {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return newPtrSkipper(t)
+	case reflect.Interface:
+		return skipInterface
+	}
+	switch k := t.Kind(); k {
+	case reflect.Bool:
+		return func(d *Decoder) error {
+			_, err := d.readBool()
+			return err
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		return func(d *Decoder) error {
+			return d.skipInt(k, d.opts.IntEncoding)
+		}
+	case reflect.Float32, reflect.Complex64:
+		return func(d *Decoder) error {
+			if d.opts.WideFloats {
+				return d.discard(2 * int64(fixedSize(k)))
+			}
+			return d.discard(int64(fixedSize(k)))
+		}
+	case reflect.Float64, reflect.Complex128:
+		return func(d *Decoder) error {
+			return d.discard(int64(fixedSize(k)))
+		}
+	case reflect.String:
+		return func(d *Decoder) error {
+			l, err := d.readLen(d.opts.MaxStringLen)
+			if err != nil {
+				return err
+			}
+			return d.discard(int64(l))
+		}
+	case reflect.Array:
+		return newArraySkipper(t)
+	case reflect.Slice:
+		return newSliceSkipper(t)
+	case reflect.Map:
+		return newMapSkipper(t)
+	case reflect.Struct:
+		return newStructSkipper(t)
+	}
+	return func(d *Decoder) error { return ErrUnsupportedValue }
+}
+
+// skipBytes skips a length prefixed byte slice written by writeBytes.
+func skipBytes(d *Decoder) error {
+	l, err := d.readLen(d.opts.MaxSliceLen)
+	if err != nil {
+		return err
+	}
+	return d.discard(int64(l))
+}
+
+// skipInt skips an int or an uint of kind k written using encoding enc.
+func (d *Decoder) skipInt(k reflect.Kind, enc IntEncoding) (err error) {
+	switch enc {
+	case IntFixed, IntFixedBE:
+		return d.discard(int64(fixedSize(k)))
+	}
+	_, err = d.readUvarint()
+	return
+}
+
+// skipInterface skips an interface value written by encInterface. Types
+// named in the stream are registered with the Decoder as if the value was
+// read.
+func skipInterface(d *Decoder) (err error) {
+	if err = d.enter(); err != nil {
+		return
+	}
+	id, err := d.readUvarint()
+	if err != nil {
+		return
+	}
+	var t reflect.Type
+	switch {
+	case id == 0:
+		d.leave()
+		return
+	case id <= uint64(len(d.types)):
+		t = d.types[id-1]
+	case id == uint64(len(d.types)+1):
+		var name string
+		if name, err = d.readString(); err != nil {
+			return
+		}
+		var ok bool
+		if t, ok = registeredType(name); !ok {
+			return ErrUnregisteredType
+		}
+		d.types = append(d.types, t)
+	default:
+		return ErrUnexpected
+	}
+	if err = skipPlanFor(t)(d); err == nil {
+		d.leave()
+	}
+	return
+}
+
+// newPtrSkipper returns a plan that skips a pointer written by
+// newPtrEncoder.
+func newPtrSkipper(t reflect.Type) skipFunc {
+	elem := skipPlanFor(t.Elem())
+	return func(d *Decoder) (err error) {
+		if err = d.enter(); err != nil {
+			return
+		}
+		switch {
+		case d.opts.References:
+			var ref uint64
+			if ref, err = d.readUvarint(); err != nil {
+				return
+			}
+			switch {
+			case ref == 1:
+				// Skipped values can not be referenced.
+				d.refs = append(d.refs, reflect.Value{})
+				if err = elem(d); err != nil {
+					return
+				}
+			case ref > 1 && ref-2 >= uint64(len(d.refs)):
+				return ErrUnexpected
+			}
+		case d.opts.NilMode == NilPreserve:
+			var present bool
+			if present, err = d.readBool(); err != nil {
+				return
+			}
+			if present {
+				if err = elem(d); err != nil {
+					return
+				}
+			}
+		default:
+			if err = elem(d); err != nil {
+				return
+			}
+		}
+		d.leave()
+		return
+	}
+}
+
+// skipPacked returns a plan that skips l elements of a packed array or
+// slice of type t.
+func (d *Decoder) skipPacked(t reflect.Type, l int) error {
+	return d.discardElems(l, fixedSize(t.Elem().Kind()))
+}
+
+// newPackedSkipper returns a plan that skips a numeric array or slice of
+// type t written by newPackedEncoder.
+func newPackedSkipper(t reflect.Type) skipFunc {
+	if t.Kind() == reflect.Array {
+		return func(d *Decoder) error {
+			return d.skipPacked(t, t.Len())
+		}
+	}
+	return func(d *Decoder) error {
+		l, err := d.readNilLen(d.opts.MaxSliceLen)
+		if err != nil || l < 0 {
+			return err
+		}
+		return d.skipPacked(t, l)
+	}
+}
+
+func newArraySkipper(t reflect.Type) skipFunc {
+	if isByteType(t.Elem()) {
+		return func(d *Decoder) error {
+			return d.discard(int64(t.Len()))
+		}
+	}
+	var packed skipFunc
+	if isFixedType(t.Elem()) {
+		packed = newPackedSkipper(t)
+	}
+	return arraySkipper(t, skipPlanFor(t.Elem()), packed)
+}
+
+// arraySkipper returns a plan that skips an array of type t element by
+// element using elem or, if packed is not nil and Packed option is set,
+// using packed.
+func arraySkipper(t reflect.Type, elem, packed skipFunc) skipFunc {
+	return func(d *Decoder) (err error) {
+		if packed != nil && d.opts.Packed {
+			return packed(d)
+		}
+		if err = d.enter(); err != nil {
+			return
+		}
+		for i := 0; i < t.Len(); i++ {
+			if err = elem(d); err != nil {
+				return
+			}
+		}
+		d.leave()
+		return
+	}
+}
+
+func newSliceSkipper(t reflect.Type) skipFunc {
+	if isByteType(t.Elem()) {
+		return func(d *Decoder) error {
+			l, err := d.readNilLen(d.opts.MaxSliceLen)
+			if err != nil || l < 0 {
+				return err
+			}
+			return d.discard(int64(l))
+		}
+	}
+	var packed skipFunc
+	if isFixedType(t.Elem()) {
+		packed = newPackedSkipper(t)
+	}
+	return sliceSkipper(skipPlanFor(t.Elem()), packed)
+}
+
+// sliceSkipper returns a plan that skips a slice element by element using
+// elem or, if packed is not nil and Packed option is set, using packed.
+func sliceSkipper(elem, packed skipFunc) skipFunc {
+	return func(d *Decoder) (err error) {
+		if packed != nil && d.opts.Packed {
+			return packed(d)
+		}
+		if err = d.enter(); err != nil {
+			return
+		}
+		l, err := d.readNilLen(d.opts.MaxSliceLen)
+		if err != nil {
+			return
+		}
+		ec := d.elemCounter(d.opts.MaxSliceLen)
+		for i := 0; i < l; i++ {
+			if err = elem(d); err != nil {
+				return
+			}
+			if err = ec.next(d); err != nil {
+				return
+			}
+		}
+		d.leave()
+		return
+	}
+}
+
+func newMapSkipper(t reflect.Type) skipFunc {
+	key := skipPlanFor(t.Key())
+	elem := skipPlanFor(t.Elem())
+	return func(d *Decoder) (err error) {
+		if err = d.enter(); err != nil {
+			return
+		}
+		l, err := d.readNilLen(d.opts.MaxMapLen)
+		if err != nil {
+			return
+		}
+		ec := d.elemCounter(d.opts.MaxMapLen)
+		for i := 0; i < l; i++ {
+			if err = key(d); err != nil {
+				return
+			}
+			if err = elem(d); err != nil {
+				return
+			}
+			if err = ec.next(d); err != nil {
+				return
+			}
+		}
+		d.leave()
+		return
+	}
+}
+
+// fieldSkipper returns a skipping plan for struct field f which follows
+// encoding options set in its' tag.
+func fieldSkipper(f structField) skipFunc {
+	switch {
+	case f.embeddedPtr:
+		return newEmbeddedPtrSkipper(f.typ)
+	case f.tag.packed:
+		return newPackedSkipper(f.typ)
+	case !f.tag.intsSet:
+	case isIntType(f.typ):
+		k, enc := f.typ.Kind(), f.tag.ints
+		return func(d *Decoder) error {
+			return d.skipInt(k, enc)
+		}
+	case !isByteType(f.typ.Elem()):
+		k, enc := f.typ.Elem().Kind(), f.tag.ints
+		elem := func(d *Decoder) error {
+			return d.skipInt(k, enc)
+		}
+		if f.typ.Kind() == reflect.Array {
+			return arraySkipper(f.typ, elem, nil)
+		}
+		return sliceSkipper(elem, nil)
+	}
+	return skipPlanFor(f.typ)
+}
+
+// newStructSkipper returns a plan that skips a struct written by
+// newStructEncoder.
+func newStructSkipper(t reflect.Type) skipFunc {
+	sf, omit, err := structFields(t)
+	if err != nil {
+		return func(d *Decoder) error { return err }
+	}
+	type skipField struct {
+		bit  int
+		skip skipFunc
+	}
+	fields := make([]skipField, 0, len(sf))
+	for _, f := range sf {
+		fields = append(fields, skipField{f.bit, fieldSkipper(f)})
+	}
+	return func(d *Decoder) (err error) {
+		if err = d.enter(); err != nil {
+			return
+		}
+		var (
+			small [8]byte
+			bm    []byte
+		)
+		if omit > 0 {
+			if n := (omit + 7) / 8; n <= len(small) {
+				bm = small[:n]
+			} else {
+				bm = make([]byte, n)
+			}
+			if err = d.readFull(bm); err != nil {
+				return
+			}
+		}
+		for _, f := range fields {
+			if f.bit >= 0 && bm[f.bit/8]&(1<<uint(f.bit%8)) == 0 {
+				continue
+			}
+			if err = f.skip(d); err != nil {
+				return
+			}
+		}
+		d.leave()
+		return
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package binaryex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type SkipTypes struct {
+	Base    BaseTypes
+	Ptrs    PointerTypes
+	Nils    NilTypes
+	Ints    IntTypes
+	Packed  PackedTypes
+	Omit    string `binaryex:",omitempty"`
+	Shapes  InterfaceTypes
+	Tree    TreeNode
+	Bytes   ByteTypes
+	Marshal MarshalableTypes
+}
+
+func (st *SkipTypes) init() {
+	st.Base.init()
+	st.Ptrs.init()
+	st.Ints = IntTypes{ID: -7, IDs: []uint32{8}, Neg: -9}
+	st.Packed = PackedTypes{Ints: []int{1, 2}, Floats: []float64{3}}
+	st.Shapes = InterfaceTypes{
+		Shape:     Rect{1, 2},
+		Shapes:    []Shape{&Circle{3}, Rect{4, 5}},
+		Any:       1,
+		AnyString: "two",
+	}
+	st.Tree = TreeNode{1, []TreeNode{{2, nil}, {3, []TreeNode{{4, nil}}}}}
+	st.Marshal.init()
+}
+
+func TestSkip(t *testing.T) {
+	var st SkipTypes
+	st.init()
+	for _, test := range []struct {
+		enc EncoderOptions
+		dec DecoderOptions
+	}{
+		{EncoderOptions{}, DecoderOptions{}},
+		{EncoderOptions{NilMode: NilPreserve, Packed: true, IntEncoding: IntFixed, WideFloats: true},
+			DecoderOptions{NilMode: NilPreserve, Packed: true, IntEncoding: IntFixed, WideFloats: true}},
+		{EncoderOptions{References: true, IntEncoding: IntVarint},
+			DecoderOptions{References: true, IntEncoding: IntVarint}},
+		{EncoderOptions{SelfDescribing: true, References: true},
+			DecoderOptions{SelfDescribing: true}},
+	} {
+		vals := []interface{}{st, "end"}
+		if test.enc.References {
+			var g RefGraph
+			g.init()
+			vals = append([]interface{}{g}, vals...)
+		}
+		buf := bytes.NewBuffer(nil)
+		enc := NewEncoderWithOptions(buf, test.enc)
+		for _, val := range vals {
+			if err := enc.Encode(val); err != nil {
+				t.Fatalf("%+v: Encode failed: %v", test.enc, err)
+			}
+		}
+		if err := enc.Flush(); err != nil {
+			t.Fatal("Flush failed", err)
+		}
+		dec := NewDecoderWithOptions(buf, test.dec)
+		for _, val := range vals[:len(vals)-1] {
+			if err := dec.Skip(reflect.TypeOf(val)); err != nil {
+				t.Fatalf("%+v: Skip %T failed: %v", test.dec, val, err)
+			}
+		}
+		var s string
+		if err := dec.Decode(&s); err != nil || s != "end" {
+			t.Fatalf("%+v: Decode after Skip failed: %q, %v", test.dec, s, err)
+		}
+		if err := dec.Skip(reflect.TypeOf("")); err != io.EOF {
+			t.Fatalf("%+v: expected io.EOF, got %v", test.dec, err)
+		}
+	}
+}
+
+func TestSkipSelfDescribing(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoderWithOptions(buf, EncoderOptions{SelfDescribing: true})
+	var st SkipTypes
+	st.init()
+	for _, val := range []interface{}{st, 42, []string{"a"}, "end"} {
+		if err := enc.Encode(val); err != nil {
+			t.Fatal("Encode failed", err)
+		}
+	}
+	dec := NewDecoderWithOptions(buf, DecoderOptions{SelfDescribing: true})
+	for i := 0; i < 3; i++ {
+		if err := dec.Skip(nil); err != nil {
+			t.Fatal("Skip failed", err)
+		}
+	}
+	var s string
+	if err := dec.Decode(&s); err != nil || s != "end" {
+		t.Fatalf("Decode after Skip failed: %q, %v", s, err)
+	}
+	if err := NewDecoder(buf).Skip(nil); err == nil {
+		t.Fatal("expected error skipping without type")
+	}
+}
+
+func TestSkipHostilePackedLength(t *testing.T) {
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	lenBuf = lenBuf[:binary.PutVarint(lenBuf, 1<<61)]
+	packed := append(append([]byte{}, lenBuf...), 1, 0, 0, 0, 0, 0, 0, 0)
+	opts := DecoderOptions{Packed: true}
+	if err := NewDecoderWithOptions(bytes.NewReader(packed), opts).Skip(reflect.TypeOf([]int64{})); !errors.Is(err, ErrUnexpected) {
+		t.Fatalf("Skip: expected ErrUnexpected, got %v", err)
+	}
+	if err := NewDecoderWithOptions(bytes.NewReader(packed), opts).Decode(new([]int64)); err == nil {
+		t.Fatal("Decode: expected error")
+	}
+
+	sd := append(append([]byte{'B', 'X', 1, 0, wtFirstID, wtSlice, wtFloat64}, lenBuf...), 0, 0, 0, 0, 0, 0, 240, 63)
+	opts = DecoderOptions{SelfDescribing: true}
+	if err := NewDecoderWithOptions(bytes.NewReader(sd), opts).Skip(nil); !errors.Is(err, ErrUnexpected) {
+		t.Fatalf("Skip: expected ErrUnexpected, got %v", err)
+	}
+	if err := NewDecoderWithOptions(bytes.NewReader(sd), opts).Decode(new([1]float64)); !errors.Is(err, ErrUnexpected) {
+		t.Fatalf("Decode: expected ErrUnexpected, got %v", err)
+	}
+}